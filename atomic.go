@@ -2,136 +2,341 @@ package supervisor
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+const (
+	// OverflowSaturate results out of range are clamped to the type limits
+	OverflowSaturate OverflowPolicy = 0
+
+	// OverflowWrap results out of range wrap around like Go integers
+	OverflowWrap OverflowPolicy = 1
+
+	// OverflowError results out of range fail with ErrAtomicOverflow and
+	// the value is not changed
+	OverflowError OverflowPolicy = 2
+)
+
+// ErrAtomicOverflow returned by integer operations with OverflowError policy
+var ErrAtomicOverflow = errors.New("Atomic value overflow")
+
+// OverflowPolicy how integer atomic values handle overflow and underflow
+type OverflowPolicy int
+
+// AtomicOptionsFunc atomic value definition
+type AtomicOptionsFunc func(*atomicOptions)
+
+type atomicOptions struct {
+	overflow    OverflowPolicy
+	retryPolicy RetryPolicy
+	promotion   *atomicPromotion
+}
+
+// SetOverflowPolicy sets how integer atomic values handle results out of
+// range, OverflowSaturate by default
+func SetOverflowPolicy(policy OverflowPolicy) AtomicOptionsFunc {
+	return func(o *atomicOptions) {
+		o.overflow = policy
+	}
+}
+
+// SetAtomicRetryPolicy overrides client retry policy for this atomic value,
+// it's used for connection errors and for conflicting changes
+func SetAtomicRetryPolicy(policy RetryPolicy) AtomicOptionsFunc {
+	return func(o *atomicOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// SetAtomicPromotedToLock makes changes that exhausted their optimistic
+// retries take a lock on lockPath and try again holding it, waiting at
+// most maxLockTime for the lock. Empty lockPath uses "<path>-lock".
+// Every client changing the value should use the same option.
+func SetAtomicPromotedToLock(lockPath string, maxLockTime time.Duration) AtomicOptionsFunc {
+	return func(o *atomicOptions) {
+		o.promotion = &atomicPromotion{lockPath: lockPath, maxLockTime: maxLockTime}
+	}
+}
+
+func newAtomicOptions(options []AtomicOptionsFunc) atomicOptions {
+	var o atomicOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
 // MakeValue the function that tries to save data will call this to transform the value
 type MakeValue func(preValue []byte) []byte
 
-// MutableAtomicValue holds value before and after save
-type MutableAtomicValue struct {
-	preValue  []byte
-	postValue []byte
+// updateValue like MakeValue but it may fail, a failure is returned
+// right away instead of being retried
+type updateValue func(preValue []byte) ([]byte, error)
+
+// valueError wraps errors returned by updateValue
+type valueError struct {
+	err error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+// ErrRetriesExhausted returned when every attempt to change an atomic
+// value failed, the value was not changed
+var ErrRetriesExhausted = errors.New("Atomic value retries exhausted")
+
+// AtomicResult outcome of an operation on an atomic value. PreValue and
+// PostValue hold the value before and after the change, when it didn't
+// succeed PreValue holds the last value read.
+type AtomicResult[T any] struct {
+	Succeeded bool
+	PreValue  T
+	PostValue T
+	Attempts  int
+
+	// Promoted true when optimistic retries were exhausted and the
+	// promotion lock was used
+	Promoted bool
+}
+
+// decodeResult converts raw result values with decode
+func decodeResult[T any](raw AtomicResult[[]byte], decode func([]byte) (T, error)) (AtomicResult[T], error) {
+	result := AtomicResult[T]{Succeeded: raw.Succeeded, Attempts: raw.Attempts, Promoted: raw.Promoted}
+
+	var err error
+	if result.PreValue, err = decode(raw.PreValue); err != nil {
+		return result, err
+	}
+	if raw.Succeeded {
+		if result.PostValue, err = decode(raw.PostValue); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
 }
 
 type atomicValue struct {
-	client         *Client
-	path           string
-	MaxRetries     int
-	RetryDelay     int
-	RetryDelayUnit time.Duration
+	ops       *clientOps
+	path      string
+	promotion *atomicPromotion
+	stats     atomicStats
 }
 
-func (av *atomicValue) getCurrentValue(result *MutableAtomicValue, _stat *zk.Stat) (bool, error) {
-	data, stat, err := av.client.checkAndGetNode(av.path)
+// atomicPromotion lock taken when optimistic retries are exhausted
+type atomicPromotion struct {
+	lockPath    string
+	maxLockTime time.Duration
+}
 
+// AtomicStats counters of changes made through an atomic value
+type AtomicStats struct {
+	// Changes committed
+	Changes int64
+	// OptimisticTries attempts made without the promotion lock
+	OptimisticTries int64
+	// Promotions times optimistic retries were exhausted and the
+	// promotion lock was taken
+	Promotions int64
+	// PromotedTries attempts made holding the promotion lock
+	PromotedTries int64
+	// PromotedFailures promotions that didn't commit the change
+	PromotedFailures int64
+}
+
+type atomicStats struct {
+	mu sync.Mutex
+	AtomicStats
+}
+
+func (s *atomicStats) add(counter *int64, n int64) {
+	s.mu.Lock()
+	*counter += n
+	s.mu.Unlock()
+}
+
+func (s *atomicStats) get() AtomicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.AtomicStats
+}
+
+// get returns current value, nil when it was never set
+func (av *atomicValue) get() ([]byte, error) {
+	data, _, err := av.ops.checkAndGetNode(av.path)
+	return data, err
+}
+
+func (av *atomicValue) compareAndSet(expected, newValue []byte) (AtomicResult[[]byte], error) {
+	result := AtomicResult[[]byte]{Attempts: 1}
+
+	data, stat, err := av.ops.checkAndGetNode(av.path)
 	if err != nil {
-		return false, err
+		return result, err
 	}
 
 	if stat == nil {
-		return false, nil
+		return result, errors.New("Value does not exists")
 	}
 
-	*_stat = *stat
-	result.preValue = data
-	return true, nil
-}
-
-func (av *atomicValue) get() (*MutableAtomicValue, error) {
-	stat := new(zk.Stat)
-	result := &MutableAtomicValue{}
+	result.PreValue = data
+	if !bytes.Equal(data, expected) {
+		return result, errors.New("Wrong data version")
+	}
 
-	if _, err := av.getCurrentValue(result, stat); err != nil {
-		return nil, err
+	if _, err := av.ops.setNodeData(av.path, newValue, stat.Version); err != nil {
+		return result, err
 	}
 
-	result.postValue = result.preValue
+	result.Succeeded = true
+	result.PostValue = newValue
 	return result, nil
 }
 
-func (av *atomicValue) compareAndSet(expected, newValue []byte) error {
-	stat := new(zk.Stat)
-	result := new(MutableAtomicValue)
+func (av *atomicValue) trySet(ctx context.Context, makeValue MakeValue) (AtomicResult[[]byte], error) {
+	return av.tryOptimistic(ctx, func(preValue []byte) ([]byte, error) {
+		return makeValue(preValue), nil
+	})
+}
 
-	exists, err := av.getCurrentValue(result, stat)
-	if err != nil {
-		return err
+// tryOptimistic tries to set the value. When another client changed it
+// meanwhile it tries again as long as the retry policy allows it, then
+// fails with ErrRetriesExhausted. When promoted to lock, retries are
+// exhausted and the lock is acquired, it tries again holding the lock.
+// Waiting between retries stops when ctx is done.
+func (av *atomicValue) tryOptimistic(ctx context.Context, update updateValue) (AtomicResult[[]byte], error) {
+	result := AtomicResult[[]byte]{}
+
+	attempts, err := av.tryWithPolicy(ctx, &result, update)
+	av.stats.add(&av.stats.OptimisticTries, int64(attempts))
+	if err == ErrRetriesExhausted && av.promotion != nil {
+		return result, av.tryPromoted(ctx, &result, update)
 	}
 
-	if !exists {
-		return errors.New("Value does not exists")
+	if err == nil {
+		av.stats.add(&av.stats.Changes, 1)
 	}
+	return result, err
+}
 
-	if !bytes.Equal(result.preValue, expected) {
-		return errors.New("Wrong data version")
+// tryPromoted tries again holding the promotion lock
+func (av *atomicValue) tryPromoted(ctx context.Context, result *AtomicResult[[]byte], update updateValue) error {
+	av.stats.add(&av.stats.Promotions, 1)
+	result.Promoted = true
+
+	lock := NewMutex(av.ops.Client, av.promotion.lockPath, SetMutexRetryPolicy(av.ops.policy))
+
+	lockCtx, cancel := context.WithTimeout(ctx, av.promotion.maxLockTime)
+	defer cancel()
+
+	if err := lock.AcquireContext(lockCtx); err != nil {
+		av.stats.add(&av.stats.PromotedFailures, 1)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		av.ops.logger.Errorf("Could not lock %s - %s", av.promotion.lockPath, err.Error())
+		return ErrRetriesExhausted
 	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			av.ops.logger.Errorf("%s", err.Error())
+		}
+	}()
 
-	if _, err := av.client.setNodeData(av.path, newValue, stat.Version); err != nil {
+	attempts, err := av.tryWithPolicy(ctx, result, update)
+	av.stats.add(&av.stats.PromotedTries, int64(attempts))
+	if err != nil {
+		av.stats.add(&av.stats.PromotedFailures, 1)
 		return err
 	}
 
-	result.postValue = newValue
+	av.stats.add(&av.stats.Changes, 1)
 	return nil
 }
 
-func (av *atomicValue) trySet(makeValue MakeValue) error {
-	return av.tryOptimistic(makeValue)
-}
+// tryWithPolicy tries to set the value until it succeeds or the retry
+// policy gives up, returns how many attempts were made
+func (av *atomicValue) tryWithPolicy(ctx context.Context, result *AtomicResult[[]byte], update updateValue) (int, error) {
+	policy := av.ops.retryPolicy()
+	start := time.Now()
+
+	for attempts := 1; ; attempts++ {
+		if err := ctx.Err(); err != nil {
+			return attempts - 1, err
+		}
 
-// tryOptimistic tries to set the value. In case of error it
-// will try again X (RetryCount) times with delay (RetryDelay).
-// Each time it receives an error, RetryDelay is increased with
-// with the following: RetryDelay = RetryDelay * 3 / 2 + 1
-func (av *atomicValue) tryOptimistic(makeValue MakeValue) error {
-	result := new(MutableAtomicValue)
-	retryCount := 0
-	retryDelay := av.RetryDelay
+		result.Attempts++
+		err := av.tryOnce(result, update)
+		if err == nil {
+			result.Succeeded = true
+			return attempts, nil
+		}
+		if verr, ok := err.(*valueError); ok {
+			return attempts, verr.err
+		}
+		if err != zk.ErrBadVersion && err != zk.ErrNodeExists {
+			// connection errors were already retried
+			return attempts, err
+		}
 
-	for retryCount < av.MaxRetries {
-		if err := av.tryOnce(result, makeValue); err == nil {
-			return nil
+		sleep, ok := policy.AllowRetry(attempts, time.Since(start))
+		if !ok {
+			return attempts, ErrRetriesExhausted
 		}
+		av.ops.logger.Debugf("Could not set %s, attempt %d - %s", av.path, result.Attempts, err.Error())
 
-		time.Sleep(time.Duration(retryDelay) * av.RetryDelayUnit)
-		retryDelay = retryDelay*3/2 + 1 // increase delay time
-		retryCount++
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, ctx.Err()
+		}
 	}
-	return nil
 }
 
-func (av *atomicValue) tryOnce(result *MutableAtomicValue, makeValue MakeValue) error {
-	stat := new(zk.Stat)
+func (av *atomicValue) tryOnce(result *AtomicResult[[]byte], update updateValue) error {
+	result.PreValue, result.PostValue = nil, nil
 
-	exists, err := av.getCurrentValue(result, stat)
+	data, stat, err := av.ops.checkAndGetNode(av.path)
 	if err != nil {
 		return err
 	}
+	result.PreValue = data
 
-	newValue := makeValue(result.preValue)
-	if exists {
-		if _, err := av.client.setNodeData(av.path, newValue, stat.Version); err != nil {
+	newValue, err := update(data)
+	if err != nil {
+		return &valueError{err: err}
+	}
+
+	if stat != nil {
+		if _, err := av.ops.setNodeData(av.path, newValue, stat.Version); err != nil {
 			return err
 		}
 	} else {
-		if _, err := av.client.createParentNodeIfNotExists(av.path, newValue); err != nil {
+		// fails when another client created it since it was read
+		if err := av.ops.createNode(av.path, newValue); err != nil {
 			return err
 		}
 	}
 
-	result.postValue = newValue
+	result.PostValue = newValue
 	return nil
 }
 
-func newAtomicValue(client *Client, path string) *atomicValue {
-	return &atomicValue{
-		client:         client,
-		path:           path,
-		MaxRetries:     3,
-		RetryDelay:     2,
-		RetryDelayUnit: time.Second,
+func newAtomicValue(client *Client, path string, options atomicOptions) *atomicValue {
+	av := atomicValue{
+		ops:       client.ops(options.retryPolicy),
+		path:      path,
+		promotion: options.promotion,
+	}
+	if av.promotion != nil && av.promotion.lockPath == "" {
+		av.promotion.lockPath = path + "-lock"
 	}
+	return &av
 }
@@ -0,0 +1,207 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+)
+
+// AtomicInt64 atomic int64, stored as little-endian two's complement
+type AtomicInt64 struct {
+	atomicValue *atomicValue
+	options     atomicOptions
+}
+
+// Increment increments current saved value
+func (ai *AtomicInt64) Increment() (AtomicResult[int64], error) {
+	return ai.IncrementContext(context.Background())
+}
+
+// IncrementContext increments current saved value, retries stop when ctx is done
+func (ai *AtomicInt64) IncrementContext(ctx context.Context) (AtomicResult[int64], error) {
+	return ai.AddContext(ctx, 1)
+}
+
+// Decrement decrements current saved value
+func (ai *AtomicInt64) Decrement() (AtomicResult[int64], error) {
+	return ai.DecrementContext(context.Background())
+}
+
+// DecrementContext decrements current saved value, retries stop when ctx is done
+func (ai *AtomicInt64) DecrementContext(ctx context.Context) (AtomicResult[int64], error) {
+	return ai.SubtractContext(ctx, 1)
+}
+
+// Add adds delta to current saved value
+func (ai *AtomicInt64) Add(delta int64) (AtomicResult[int64], error) {
+	return ai.AddContext(context.Background(), delta)
+}
+
+// AddContext adds delta to current saved value, retries stop when ctx is done
+func (ai *AtomicInt64) AddContext(ctx context.Context, delta int64) (AtomicResult[int64], error) {
+	return ai.update(ctx, func(pre int64) (int64, error) {
+		return ai.add(pre, delta)
+	})
+}
+
+// Subtract subtracts delta from current saved value
+func (ai *AtomicInt64) Subtract(delta int64) (AtomicResult[int64], error) {
+	return ai.SubtractContext(context.Background(), delta)
+}
+
+// SubtractContext subtracts delta from current saved value, retries stop
+// when ctx is done
+func (ai *AtomicInt64) SubtractContext(ctx context.Context, delta int64) (AtomicResult[int64], error) {
+	return ai.update(ctx, func(pre int64) (int64, error) {
+		return ai.subtract(pre, delta)
+	})
+}
+
+// GetAndAdd adds delta to current saved value, the previous value is
+// the result PreValue
+func (ai *AtomicInt64) GetAndAdd(delta int64) (AtomicResult[int64], error) {
+	return ai.GetAndAddContext(context.Background(), delta)
+}
+
+// GetAndAddContext adds delta to current saved value, the previous value
+// is the result PreValue. Retries stop when ctx is done.
+func (ai *AtomicInt64) GetAndAddContext(ctx context.Context, delta int64) (AtomicResult[int64], error) {
+	return ai.AddContext(ctx, delta)
+}
+
+// AddAndGet adds delta to current saved value, the new value is the
+// result PostValue
+func (ai *AtomicInt64) AddAndGet(delta int64) (AtomicResult[int64], error) {
+	return ai.AddAndGetContext(context.Background(), delta)
+}
+
+// AddAndGetContext adds delta to current saved value, the new value is
+// the result PostValue. Retries stop when ctx is done.
+func (ai *AtomicInt64) AddAndGetContext(ctx context.Context, delta int64) (AtomicResult[int64], error) {
+	return ai.AddContext(ctx, delta)
+}
+
+// GetAndSet sets new value, the previous value is the result PreValue
+func (ai *AtomicInt64) GetAndSet(v int64) (AtomicResult[int64], error) {
+	return ai.GetAndSetContext(context.Background(), v)
+}
+
+// GetAndSetContext sets new value, the previous value is the result
+// PreValue. Retries stop when ctx is done.
+func (ai *AtomicInt64) GetAndSetContext(ctx context.Context, v int64) (AtomicResult[int64], error) {
+	return ai.TrySetContext(ctx, v)
+}
+
+// TrySet tries to set or override with new value
+func (ai *AtomicInt64) TrySet(v int64) (AtomicResult[int64], error) {
+	return ai.TrySetContext(context.Background(), v)
+}
+
+// TrySetContext tries to set or override with new value, retries stop when ctx is done
+func (ai *AtomicInt64) TrySetContext(ctx context.Context, v int64) (AtomicResult[int64], error) {
+	raw, err := ai.atomicValue.trySet(ctx, func(preValue []byte) []byte {
+		return ai.toBytes(v)
+	})
+	return ai.result(raw), err
+}
+
+// Get retries current value
+func (ai *AtomicInt64) Get() (int64, error) {
+	data, err := ai.atomicValue.get()
+	if err != nil {
+		return 0, err
+	}
+	return ai.fromBytes(data), nil
+}
+
+// CompareAndSet compares expected value with current value
+// if it's equals, than change it's value with newValue
+func (ai *AtomicInt64) CompareAndSet(expected, newValue int64) (AtomicResult[int64], error) {
+	raw, err := ai.atomicValue.compareAndSet(ai.toBytes(expected), ai.toBytes(newValue))
+	return ai.result(raw), err
+}
+
+// Watch sends every change of the value until ctx is done
+func (ai *AtomicInt64) Watch(ctx context.Context) <-chan ValueChange[int64] {
+	return watchValues(ctx, ai.atomicValue, func(data []byte) (int64, error) {
+		return ai.fromBytes(data), nil
+	})
+}
+
+// Stats returns counters of changes made through this value
+func (ai *AtomicInt64) Stats() AtomicStats {
+	return ai.atomicValue.stats.get()
+}
+
+// update applies fn to current saved value
+func (ai *AtomicInt64) update(ctx context.Context, fn func(int64) (int64, error)) (AtomicResult[int64], error) {
+	raw, err := ai.atomicValue.tryOptimistic(ctx, func(preValue []byte) ([]byte, error) {
+		post, err := fn(ai.fromBytes(preValue))
+		if err != nil {
+			return nil, err
+		}
+		return ai.toBytes(post), nil
+	})
+	return ai.result(raw), err
+}
+
+func (ai *AtomicInt64) result(raw AtomicResult[[]byte]) AtomicResult[int64] {
+	result, _ := decodeResult(raw, func(data []byte) (int64, error) {
+		return ai.fromBytes(data), nil
+	})
+	return result
+}
+
+func (ai *AtomicInt64) add(pre, delta int64) (int64, error) {
+	post := pre + delta
+	if (delta > 0 && post < pre) || (delta < 0 && post > pre) {
+		return ai.overflow(post, delta > 0)
+	}
+	return post, nil
+}
+
+func (ai *AtomicInt64) subtract(pre, delta int64) (int64, error) {
+	post := pre - delta
+	if (delta > 0 && post > pre) || (delta < 0 && post < pre) {
+		return ai.overflow(post, delta < 0)
+	}
+	return post, nil
+}
+
+// overflow applies overflow policy to the wrapped result, up is true when
+// the result went over the max value
+func (ai *AtomicInt64) overflow(wrapped int64, up bool) (int64, error) {
+	switch ai.options.overflow {
+	case OverflowWrap:
+		return wrapped, nil
+	case OverflowError:
+		return 0, ErrAtomicOverflow
+	}
+	if up {
+		return math.MaxInt64, nil
+	}
+	return math.MinInt64, nil
+}
+
+func (ai *AtomicInt64) toBytes(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+// fromBytes returns zero for missing values
+func (ai *AtomicInt64) fromBytes(b []byte) int64 {
+	if len(b) < 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// NewAtomicInt64 returns new AtomicInt64
+func NewAtomicInt64(client *Client, path string, options ...AtomicOptionsFunc) *AtomicInt64 {
+	al := AtomicInt64{
+		options: newAtomicOptions(options),
+	}
+	al.atomicValue = newAtomicValue(client, path, al.options)
+	return &al
+}
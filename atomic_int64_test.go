@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtomicInt64Arithmetic(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newTestClient()
+
+	vint64 := NewAtomicInt64(client, "/supervisor/test/atomic/int64/var01")
+	_, err := vint64.TrySet(2)
+	assert.Equal(err, nil)
+
+	result, err := vint64.Subtract(5)
+	assert.Equal(err, nil)
+	assert.Equal(result.PreValue, int64(2))
+	assert.Equal(result.PostValue, int64(-3))
+
+	result, _ = vint64.GetAndAdd(-2)
+	assert.Equal(result.PreValue, int64(-3))
+
+	result, _ = vint64.AddAndGet(10)
+	assert.Equal(result.PostValue, int64(5))
+
+	_, err = vint64.Decrement()
+	assert.Equal(err, nil)
+	_, err = vint64.Increment()
+	assert.Equal(err, nil)
+	_, err = vint64.Increment()
+	assert.Equal(err, nil)
+
+	result, _ = vint64.GetAndSet(-100)
+	assert.Equal(result.PreValue, int64(6))
+	assert.Equal(result.PostValue, int64(-100))
+
+	_, err = vint64.CompareAndSet(-100, 7)
+	assert.Equal(err, nil)
+	val, _ := vint64.Get()
+	assert.Equal(val, int64(7))
+
+	client.Disconnect()
+}
+
+func TestAtomicInt64OverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newTestClient()
+
+	saturate := NewAtomicInt64(client, "/supervisor/test/atomic/int64/var02")
+	_, err := saturate.TrySet(math.MaxInt64 - 1)
+	assert.Equal(err, nil)
+	result, _ := saturate.Add(5)
+	assert.Equal(result.PostValue, int64(math.MaxInt64))
+	result, _ = saturate.Subtract(-1)
+	assert.Equal(result.PostValue, int64(math.MaxInt64))
+
+	wrap := NewAtomicInt64(client, "/supervisor/test/atomic/int64/var03", SetOverflowPolicy(OverflowWrap))
+	_, err = wrap.TrySet(math.MinInt64)
+	assert.Equal(err, nil)
+	result, _ = wrap.Subtract(1)
+	assert.Equal(result.PostValue, int64(math.MaxInt64))
+
+	fail := NewAtomicInt64(client, "/supervisor/test/atomic/int64/var04", SetOverflowPolicy(OverflowError))
+	_, err = fail.TrySet(math.MinInt64 + 1)
+	assert.Equal(err, nil)
+	_, err = fail.Add(-2)
+	assert.Equal(err, ErrAtomicOverflow)
+	val, _ := fail.Get()
+	assert.Equal(val, int64(math.MinInt64+1))
+
+	client.Disconnect()
+}
@@ -0,0 +1,114 @@
+package supervisor
+
+import (
+	"context"
+)
+
+// Atomic atomic value of any type, codec converts it to and from node data
+type Atomic[T any] struct {
+	atomicValue *atomicValue
+	codec       Codec[T]
+}
+
+// Get returns current value, zero value when it was never set
+func (a *Atomic[T]) Get() (T, error) {
+	data, err := a.atomicValue.get()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return a.decode(data)
+}
+
+// Set sets or overrides with new value
+func (a *Atomic[T]) Set(v T) (AtomicResult[T], error) {
+	return a.SetContext(context.Background(), v)
+}
+
+// SetContext sets or overrides with new value, retries stop when ctx is done
+func (a *Atomic[T]) SetContext(ctx context.Context, v T) (AtomicResult[T], error) {
+	data, err := a.codec.Encode(v)
+	if err != nil {
+		return AtomicResult[T]{}, err
+	}
+
+	raw, err := a.atomicValue.trySet(ctx, func(preValue []byte) []byte {
+		return data
+	})
+	return a.result(raw, err)
+}
+
+// CompareAndSet compares expected value with current value
+// if it's equals, than change it's value with newValue.
+// Values are compared once encoded.
+func (a *Atomic[T]) CompareAndSet(expected, newValue T) (AtomicResult[T], error) {
+	expectedData, err := a.codec.Encode(expected)
+	if err != nil {
+		return AtomicResult[T]{}, err
+	}
+
+	newData, err := a.codec.Encode(newValue)
+	if err != nil {
+		return AtomicResult[T]{}, err
+	}
+
+	return a.result(a.atomicValue.compareAndSet(expectedData, newData))
+}
+
+// Update replaces current value with fn result. fn is called again when
+// another client changes the value meanwhile, so it must not have side
+// effects.
+func (a *Atomic[T]) Update(fn func(T) T) (AtomicResult[T], error) {
+	return a.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext replaces current value with fn result, retries stop when
+// ctx is done
+func (a *Atomic[T]) UpdateContext(ctx context.Context, fn func(T) T) (AtomicResult[T], error) {
+	raw, err := a.atomicValue.tryOptimistic(ctx, func(preValue []byte) ([]byte, error) {
+		pre, err := a.decode(preValue)
+		if err != nil {
+			return nil, err
+		}
+		return a.codec.Encode(fn(pre))
+	})
+	return a.result(raw, err)
+}
+
+// Watch sends every change of the value until ctx is done, values
+// failing to decode are skipped
+func (a *Atomic[T]) Watch(ctx context.Context) <-chan ValueChange[T] {
+	return watchValues(ctx, a.atomicValue, a.decode)
+}
+
+// Stats returns counters of changes made through this value
+func (a *Atomic[T]) Stats() AtomicStats {
+	return a.atomicValue.stats.get()
+}
+
+// result decodes raw result values, err is returned unless decoding fails
+func (a *Atomic[T]) result(raw AtomicResult[[]byte], err error) (AtomicResult[T], error) {
+	result, derr := decodeResult(raw, a.decode)
+	if derr != nil && err == nil {
+		err = derr
+	}
+	return result, err
+}
+
+// decode returns zero value for missing or empty nodes
+func (a *Atomic[T]) decode(data []byte) (T, error) {
+	if len(data) == 0 {
+		var zero T
+		return zero, nil
+	}
+	return a.codec.Decode(data)
+}
+
+// NewAtomic returns new Atomic stored at path with codec
+func NewAtomic[T any](client *Client, path string, codec Codec[T], options ...AtomicOptionsFunc) *Atomic[T] {
+	a := Atomic[T]{
+		atomicValue: newAtomicValue(client, path, newAtomicOptions(options)),
+		codec:       codec,
+	}
+	return &a
+}
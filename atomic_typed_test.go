@@ -0,0 +1,203 @@
+package supervisor
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct {
+	Name    string
+	Servers []string
+	Version int
+}
+
+// testCounter message with a single varint field, encoded by hand
+type testCounter struct {
+	Value uint64
+}
+
+func (m *testCounter) Marshal() ([]byte, error) {
+	return binary.AppendUvarint([]byte{0x08}, m.Value), nil
+}
+
+func (m *testCounter) Unmarshal(data []byte) error {
+	if len(data) < 2 || data[0] != 0x08 {
+		return errors.New("Invalid message")
+	}
+	value, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return errors.New("Invalid message")
+	}
+	m.Value = value
+	return nil
+}
+
+func TestAtomicJSON(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+
+	config := NewAtomic[testConfig](client, "/supervisor/test/atomic/typed/json", JSONCodec[testConfig]{})
+
+	val, err := config.Get()
+	assert.Equal(err, nil)
+	assert.Equal(val, testConfig{})
+
+	initial := testConfig{Name: "db", Servers: []string{"10.0.0.1"}, Version: 1}
+	_, err = config.Set(initial)
+	assert.Equal(err, nil)
+
+	val, _ = config.Get()
+	assert.Equal(val, initial)
+
+	result, err := config.Update(func(c testConfig) testConfig {
+		c.Servers = append(c.Servers, "10.0.0.2")
+		c.Version++
+		return c
+	})
+	assert.Equal(err, nil)
+	assert.Equal(result.PreValue, initial)
+	updated := result.PostValue
+	assert.Equal(updated.Version, 2)
+	assert.Equal(updated.Servers, []string{"10.0.0.1", "10.0.0.2"})
+
+	_, err = config.CompareAndSet(initial, testConfig{Name: "other"})
+	assert.NotEqual(err, nil)
+	_, err = config.CompareAndSet(updated, testConfig{Name: "other"})
+	assert.Equal(err, nil)
+
+	val, _ = config.Get()
+	assert.Equal(val, testConfig{Name: "other"})
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion("/supervisor/test/atomic/typed/json"), nil)
+	client.Disconnect()
+}
+
+func TestAtomicGob(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+
+	config := NewAtomic[testConfig](client, "/supervisor/test/atomic/typed/gob", GobCodec[testConfig]{})
+	_, err := config.Set(testConfig{Name: "cache", Version: 3})
+	assert.Equal(err, nil)
+
+	val, err := config.Get()
+	assert.Equal(err, nil)
+	assert.Equal(val, testConfig{Name: "cache", Version: 3})
+
+	client.Disconnect()
+}
+
+func TestAtomicProto(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+
+	counter := NewAtomic[*testCounter](client, "/supervisor/test/atomic/typed/proto", NewProtoCodec[testCounter]())
+	_, err := counter.Set(&testCounter{Value: 300})
+	assert.Equal(err, nil)
+
+	val, err := counter.Get()
+	assert.Equal(err, nil)
+	assert.Equal(val.Value, uint64(300))
+
+	result, err := counter.Update(func(c *testCounter) *testCounter {
+		return &testCounter{Value: c.Value + 1}
+	})
+	assert.Equal(err, nil)
+	assert.Equal(result.PostValue.Value, uint64(301))
+
+	client.Disconnect()
+}
+
+func TestAtomicRawDecodeError(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+	path := "/supervisor/test/atomic/typed/raw"
+
+	raw := NewAtomic[[]byte](client, path, RawCodec{})
+	_, err := raw.Set([]byte("not json"))
+	assert.Equal(err, nil)
+
+	val, _ := raw.Get()
+	assert.Equal(val, []byte("not json"))
+
+	// decode errors are returned without retrying
+	config := NewAtomic[testConfig](client, path, JSONCodec[testConfig]{})
+	result, err := config.Update(func(c testConfig) testConfig {
+		return c
+	})
+	assert.NotEqual(err, nil)
+	assert.False(result.Succeeded)
+	assert.Equal(result.Attempts, 1)
+
+	client.Disconnect()
+}
+
+func TestAtomicRetriesExhausted(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/atomic/typed/conflict"
+
+	counter := NewAtomic[int](clients[0], path, JSONCodec[int]{},
+		SetAtomicRetryPolicy(NewFixedRetry(2, time.Millisecond)))
+	other := NewAtomic[int](clients[1], path, JSONCodec[int]{})
+	_, err := counter.Set(1)
+	assert.Equal(err, nil)
+
+	// another client changes the value before every attempt commits
+	result, err := counter.Update(func(v int) int {
+		other.Set(v + 10)
+		return v + 1
+	})
+	assert.Equal(err, ErrRetriesExhausted)
+	assert.False(result.Succeeded)
+	assert.Equal(result.Attempts, 3)
+
+	val, _ := counter.Get()
+	assert.Equal(val, 31)
+
+	closeClients(clients)
+}
+
+func TestAtomicPromotedToLock(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/atomic/typed/promoted"
+
+	counter := NewAtomic[int](clients[0], path, JSONCodec[int]{},
+		SetAtomicRetryPolicy(NewFixedRetry(2, time.Millisecond)),
+		SetAtomicPromotedToLock("", time.Second))
+	other := NewAtomic[int](clients[1], path, JSONCodec[int]{})
+	_, err := counter.Set(1)
+	assert.Equal(err, nil)
+
+	// another client wins every optimistic attempt
+	calls := 0
+	result, err := counter.Update(func(v int) int {
+		calls++
+		if calls <= 3 {
+			other.Set(v + 10)
+		}
+		return v + 1
+	})
+	assert.Equal(err, nil)
+	assert.True(result.Succeeded)
+	assert.True(result.Promoted)
+	assert.Equal(result.Attempts, 4)
+	assert.Equal(result.PostValue, 32)
+
+	assert.Equal(counter.Stats(), AtomicStats{
+		Changes:         2,
+		OptimisticTries: 4,
+		Promotions:      1,
+		PromotedTries:   1,
+	})
+
+	exists, _, _ := clients[0].backend.Exists(path + "-lock")
+	assert.False(exists)
+
+	closeClients(clients)
+}
@@ -1,68 +1,185 @@
 package supervisor
 
-import "encoding/binary"
+import (
+	"context"
+	"encoding/binary"
+	"math"
+)
 
 // AtomicUint64 atomic uint64
 type AtomicUint64 struct {
 	atomicValue *atomicValue
+	options     atomicOptions
 }
 
 // Increment increments current saved value
-func (ai64 *AtomicUint64) Increment() error {
-	return ai64.atomicValue.trySet(func(preValue []byte) []byte {
-		var pre uint64
+func (ai64 *AtomicUint64) Increment() (AtomicResult[uint64], error) {
+	return ai64.IncrementContext(context.Background())
+}
 
-		if preValue != nil {
-			pre = ai64.fromBytes(preValue)
-		}
+// IncrementContext increments current saved value, retries stop when ctx is done
+func (ai64 *AtomicUint64) IncrementContext(ctx context.Context) (AtomicResult[uint64], error) {
+	return ai64.AddContext(ctx, 1)
+}
+
+// Decrement decrements current saved value, with OverflowSaturate policy
+// it stays at zero
+func (ai64 *AtomicUint64) Decrement() (AtomicResult[uint64], error) {
+	return ai64.DecrementContext(context.Background())
+}
+
+// DecrementContext decrements current saved value, retries stop when ctx is done
+func (ai64 *AtomicUint64) DecrementContext(ctx context.Context) (AtomicResult[uint64], error) {
+	return ai64.SubtractContext(ctx, 1)
+}
 
-		post := pre + 1
+// Add adds delta to current saved value
+func (ai64 *AtomicUint64) Add(delta uint64) (AtomicResult[uint64], error) {
+	return ai64.AddContext(context.Background(), delta)
+}
 
-		return ai64.toBytes(post)
+// AddContext adds delta to current saved value, retries stop when ctx is done
+func (ai64 *AtomicUint64) AddContext(ctx context.Context, delta uint64) (AtomicResult[uint64], error) {
+	return ai64.update(ctx, func(pre uint64) (uint64, error) {
+		return ai64.add(pre, delta)
 	})
 }
 
-// Decrement decrements current saved value
-func (ai64 *AtomicUint64) Decrement() error {
-	return ai64.atomicValue.trySet(func(preValue []byte) []byte {
-		var pre uint64
+// Subtract subtracts delta from current saved value
+func (ai64 *AtomicUint64) Subtract(delta uint64) (AtomicResult[uint64], error) {
+	return ai64.SubtractContext(context.Background(), delta)
+}
 
-		if preValue != nil {
-			pre = ai64.fromBytes(preValue)
-		}
+// SubtractContext subtracts delta from current saved value, retries stop
+// when ctx is done
+func (ai64 *AtomicUint64) SubtractContext(ctx context.Context, delta uint64) (AtomicResult[uint64], error) {
+	return ai64.update(ctx, func(pre uint64) (uint64, error) {
+		return ai64.subtract(pre, delta)
+	})
+}
 
-		post := pre
+// GetAndAdd adds delta to current saved value, the previous value is
+// the result PreValue
+func (ai64 *AtomicUint64) GetAndAdd(delta uint64) (AtomicResult[uint64], error) {
+	return ai64.GetAndAddContext(context.Background(), delta)
+}
 
-		if pre > 0 {
-			post--
-		} else {
-			post = 0
-		}
+// GetAndAddContext adds delta to current saved value, the previous value
+// is the result PreValue. Retries stop when ctx is done.
+func (ai64 *AtomicUint64) GetAndAddContext(ctx context.Context, delta uint64) (AtomicResult[uint64], error) {
+	return ai64.AddContext(ctx, delta)
+}
 
-		return ai64.toBytes(post)
-	})
+// AddAndGet adds delta to current saved value, the new value is the
+// result PostValue
+func (ai64 *AtomicUint64) AddAndGet(delta uint64) (AtomicResult[uint64], error) {
+	return ai64.AddAndGetContext(context.Background(), delta)
+}
+
+// AddAndGetContext adds delta to current saved value, the new value is
+// the result PostValue. Retries stop when ctx is done.
+func (ai64 *AtomicUint64) AddAndGetContext(ctx context.Context, delta uint64) (AtomicResult[uint64], error) {
+	return ai64.AddContext(ctx, delta)
+}
+
+// GetAndSet sets new value, the previous value is the result PreValue
+func (ai64 *AtomicUint64) GetAndSet(v uint64) (AtomicResult[uint64], error) {
+	return ai64.GetAndSetContext(context.Background(), v)
+}
+
+// GetAndSetContext sets new value, the previous value is the result
+// PreValue. Retries stop when ctx is done.
+func (ai64 *AtomicUint64) GetAndSetContext(ctx context.Context, v uint64) (AtomicResult[uint64], error) {
+	return ai64.TrySetContext(ctx, v)
 }
 
 // TrySet tries to set or override with new value
-func (ai64 *AtomicUint64) TrySet(v uint64) error {
-	return ai64.atomicValue.trySet(func(preValue []byte) []byte {
+func (ai64 *AtomicUint64) TrySet(v uint64) (AtomicResult[uint64], error) {
+	return ai64.TrySetContext(context.Background(), v)
+}
+
+// TrySetContext tries to set or override with new value, retries stop when ctx is done
+func (ai64 *AtomicUint64) TrySetContext(ctx context.Context, v uint64) (AtomicResult[uint64], error) {
+	raw, err := ai64.atomicValue.trySet(ctx, func(preValue []byte) []byte {
 		return ai64.toBytes(v)
 	})
+	return ai64.result(raw), err
 }
 
 // Get retries current value
 func (ai64 *AtomicUint64) Get() (uint64, error) {
-	av, err := ai64.atomicValue.get()
+	data, err := ai64.atomicValue.get()
 	if err != nil {
 		return 0, err
 	}
-	return ai64.fromBytes(av.postValue), nil
+	return ai64.fromBytes(data), nil
 }
 
 // CompareAndSet compares expected value with current value
 // if it's equals, than change it's value with newValue
-func (ai64 *AtomicUint64) CompareAndSet(expected, newValue uint64) error {
-	return ai64.atomicValue.compareAndSet(ai64.toBytes(expected), ai64.toBytes(newValue))
+func (ai64 *AtomicUint64) CompareAndSet(expected, newValue uint64) (AtomicResult[uint64], error) {
+	raw, err := ai64.atomicValue.compareAndSet(ai64.toBytes(expected), ai64.toBytes(newValue))
+	return ai64.result(raw), err
+}
+
+// Watch sends every change of the value until ctx is done
+func (ai64 *AtomicUint64) Watch(ctx context.Context) <-chan ValueChange[uint64] {
+	return watchValues(ctx, ai64.atomicValue, func(data []byte) (uint64, error) {
+		return ai64.fromBytes(data), nil
+	})
+}
+
+// Stats returns counters of changes made through this value
+func (ai64 *AtomicUint64) Stats() AtomicStats {
+	return ai64.atomicValue.stats.get()
+}
+
+// update applies fn to current saved value
+func (ai64 *AtomicUint64) update(ctx context.Context, fn func(uint64) (uint64, error)) (AtomicResult[uint64], error) {
+	raw, err := ai64.atomicValue.tryOptimistic(ctx, func(preValue []byte) ([]byte, error) {
+		post, err := fn(ai64.fromBytes(preValue))
+		if err != nil {
+			return nil, err
+		}
+		return ai64.toBytes(post), nil
+	})
+	return ai64.result(raw), err
+}
+
+func (ai64 *AtomicUint64) result(raw AtomicResult[[]byte]) AtomicResult[uint64] {
+	result, _ := decodeResult(raw, func(data []byte) (uint64, error) {
+		return ai64.fromBytes(data), nil
+	})
+	return result
+}
+
+func (ai64 *AtomicUint64) add(pre, delta uint64) (uint64, error) {
+	post := pre + delta
+	if post >= pre {
+		return post, nil
+	}
+
+	switch ai64.options.overflow {
+	case OverflowWrap:
+		return post, nil
+	case OverflowError:
+		return 0, ErrAtomicOverflow
+	}
+	return math.MaxUint64, nil
+}
+
+func (ai64 *AtomicUint64) subtract(pre, delta uint64) (uint64, error) {
+	if delta <= pre {
+		return pre - delta, nil
+	}
+
+	switch ai64.options.overflow {
+	case OverflowWrap:
+		return pre - delta, nil
+	case OverflowError:
+		return 0, ErrAtomicOverflow
+	}
+	return 0, nil
 }
 
 func (ai64 *AtomicUint64) toBytes(v uint64) []byte {
@@ -71,14 +188,19 @@ func (ai64 *AtomicUint64) toBytes(v uint64) []byte {
 	return b
 }
 
+// fromBytes returns zero for missing values
 func (ai64 *AtomicUint64) fromBytes(b []byte) uint64 {
+	if len(b) < 8 {
+		return 0
+	}
 	return binary.LittleEndian.Uint64(b)
 }
 
 // NewAtomicUint64 returns new AtomicUint64
-func NewAtomicUint64(client *Client, path string) *AtomicUint64 {
+func NewAtomicUint64(client *Client, path string, options ...AtomicOptionsFunc) *AtomicUint64 {
 	al := AtomicUint64{
-		atomicValue: newAtomicValue(client, path),
+		options: newAtomicOptions(options),
 	}
+	al.atomicValue = newAtomicValue(client, path, al.options)
 	return &al
 }
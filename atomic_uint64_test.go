@@ -1,25 +1,23 @@
 package supervisor
 
 import (
-	"fmt"
+	"context"
+	"math"
 	"testing"
+	"time"
 
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAtomicUint64SetGet(t *testing.T) {
 	assert := assert.New(t)
 
-	client := NewClient(
-		SetZookeeperNodes("127.0.0.1"),
-	)
-
-	if err := client.Connect(); err != nil {
-		fmt.Println(err.Error())
-	}
+	client := newTestClient()
 
 	vint64 := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var01")
-	assert.Equal(vint64.TrySet(10), nil)
+	_, err := vint64.TrySet(10)
+	assert.Equal(err, nil)
 
 	val, _ := vint64.Get()
 	assert.Equal(val, uint64(10))
@@ -30,25 +28,24 @@ func TestAtomicUint64SetGet(t *testing.T) {
 func TestAtomicUint64SetGetIncDec(t *testing.T) {
 	assert := assert.New(t)
 
-	client := NewClient(
-		SetZookeeperNodes("127.0.0.1"),
-	)
-
-	if err := client.Connect(); err != nil {
-		fmt.Println(err.Error())
-	}
+	client := newTestClient()
 
 	vint64 := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var02")
-	assert.Equal(vint64.TrySet(10), nil)
+	_, err := vint64.TrySet(10)
+	assert.Equal(err, nil)
 
 	val, _ := vint64.Get()
 	assert.Equal(val, uint64(10))
 
-	assert.Equal(vint64.Increment(), nil)
+	result, err := vint64.Increment()
+	assert.Equal(err, nil)
+	assert.Equal(result, AtomicResult[uint64]{Succeeded: true, PreValue: 10, PostValue: 11, Attempts: 1})
 	val, _ = vint64.Get()
 	assert.Equal(val, uint64(11))
 
-	assert.Equal(vint64.Decrement(), nil)
+	result, err = vint64.Decrement()
+	assert.Equal(err, nil)
+	assert.Equal(result.PostValue, uint64(10))
 	val, _ = vint64.Get()
 	assert.Equal(val, uint64(10))
 
@@ -58,20 +55,261 @@ func TestAtomicUint64SetGetIncDec(t *testing.T) {
 func TestAtomicUint64CompareAndSet(t *testing.T) {
 	assert := assert.New(t)
 
-	client := NewClient(
-		SetZookeeperNodes("127.0.0.1"),
-	)
-
-	if err := client.Connect(); err != nil {
-		fmt.Println(err.Error())
-	}
+	client := newTestClient()
 
 	vint64 := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var03")
-	assert.Equal(vint64.TrySet(10), nil)
+	_, err := vint64.TrySet(10)
+	assert.Equal(err, nil)
 
-	assert.Equal(vint64.CompareAndSet(10, 20), nil)
+	result, err := vint64.CompareAndSet(10, 20)
+	assert.Equal(err, nil)
+	assert.True(result.Succeeded)
 	val, _ := vint64.Get()
 	assert.Equal(val, uint64(20))
 
+	result, err = vint64.CompareAndSet(10, 30)
+	assert.NotEqual(err, nil)
+	assert.False(result.Succeeded)
+	assert.Equal(result.PreValue, uint64(20))
+
+	client.Disconnect()
+}
+
+func TestAtomicUint64Arithmetic(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newTestClient()
+
+	vint64 := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var04")
+
+	result, err := vint64.Add(5)
+	assert.Equal(err, nil)
+	assert.Equal(result.PreValue, uint64(0))
+	assert.Equal(result.PostValue, uint64(5))
+
+	result, _ = vint64.GetAndAdd(3)
+	assert.Equal(result.PreValue, uint64(5))
+
+	result, _ = vint64.AddAndGet(2)
+	assert.Equal(result.PostValue, uint64(10))
+
+	result, _ = vint64.Subtract(4)
+	assert.Equal(result.PostValue, uint64(6))
+
+	result, _ = vint64.GetAndSet(100)
+	assert.Equal(result.PreValue, uint64(6))
+
+	val, _ := vint64.Get()
+	assert.Equal(val, uint64(100))
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion("/supervisor/test/atomic/uint64/var04"), nil)
+	client.Disconnect()
+}
+
+func TestAtomicUint64OverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newTestClient()
+
+	saturate := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var05")
+	_, err := saturate.TrySet(1)
+	assert.Equal(err, nil)
+	result, _ := saturate.Subtract(2)
+	assert.Equal(result.PostValue, uint64(0))
+
+	wrap := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var06", SetOverflowPolicy(OverflowWrap))
+	_, err = wrap.TrySet(1)
+	assert.Equal(err, nil)
+	result, _ = wrap.Subtract(2)
+	assert.Equal(result.PostValue, uint64(math.MaxUint64))
+	result, _ = wrap.Add(1)
+	assert.Equal(result.PostValue, uint64(0))
+
+	fail := NewAtomicUint64(client, "/supervisor/test/atomic/uint64/var07", SetOverflowPolicy(OverflowError))
+	_, err = fail.TrySet(1)
+	assert.Equal(err, nil)
+	result, err = fail.Subtract(2)
+	assert.Equal(err, ErrAtomicOverflow)
+	assert.False(result.Succeeded)
+	_, err = fail.Decrement()
+	assert.Equal(err, nil)
+	_, err = fail.Decrement()
+	assert.Equal(err, ErrAtomicOverflow)
+	val, _ := fail.Get()
+	assert.Equal(val, uint64(0))
+
+	client.Disconnect()
+}
+
+func TestAtomicUint64RetriesExhausted(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/atomic/uint64/var08"
+
+	client := newTestClient()
+	conflicting := newConflictingClient()
+
+	_, err := NewAtomicUint64(client, path).TrySet(10)
+	assert.Equal(err, nil)
+
+	vint64 := NewAtomicUint64(conflicting, path, SetAtomicRetryPolicy(NewFixedRetry(2, time.Millisecond)))
+
+	// the value changes before every attempt commits
+	result, err := vint64.Increment()
+	assert.Equal(err, ErrRetriesExhausted)
+	assert.False(result.Succeeded)
+	assert.Equal(result.Attempts, 3)
+
+	val, _ := vint64.Get()
+	assert.Equal(val, uint64(10))
+
+	client.Disconnect()
+	conflicting.Disconnect()
+}
+
+// conflictingBackend changes a node right before every versioned set, so
+// every optimistic attempt fails
+type conflictingBackend struct {
+	*MemoryBackend
+}
+
+func (b *conflictingBackend) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	if version != -1 {
+		current, _, _ := b.MemoryBackend.Get(path)
+		b.MemoryBackend.Set(path, current, -1)
+	}
+	return b.MemoryBackend.Set(path, data, version)
+}
+
+func newConflictingClient() *Client {
+	client := NewClient(SetBackend(&conflictingBackend{testStore.NewBackend()}))
+	client.Connect()
+	return client
+}
+
+func TestAtomicUint64ContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/atomic/uint64/var09"
+
+	client := newTestClient()
+	conflicting := newConflictingClient()
+
+	_, err := NewAtomicUint64(client, path).TrySet(10)
+	assert.Equal(err, nil)
+
+	vint64 := NewAtomicUint64(conflicting, path, SetAtomicRetryPolicy(NewForeverRetry(10*time.Millisecond)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := vint64.IncrementContext(ctx)
+	assert.Equal(err, context.Canceled)
+	assert.Equal(result.Attempts, 0)
+
+	// retries stop when ctx is done
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	result, err = vint64.AddContext(ctx, 5)
+	cancel()
+	assert.Equal(err, context.DeadlineExceeded)
+	assert.False(result.Succeeded)
+	assert.True(result.Attempts > 1)
+
+	_, err = vint64.GetAndAddContext(ctx, 5)
+	assert.Equal(err, context.DeadlineExceeded)
+
+	val, _ := vint64.Get()
+	assert.Equal(val, uint64(10))
+
+	client.Disconnect()
+	conflicting.Disconnect()
+}
+
+func TestAtomicUint64ContextCanceledWaitingPromotion(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/atomic/uint64/var10"
+	lockPath := path + "-lock"
+
+	client := newTestClient()
+	conflicting := newConflictingClient()
+
+	_, err := NewAtomicUint64(client, path).TrySet(10)
+	assert.Equal(err, nil)
+
+	holder := NewMutex(client, lockPath)
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+
+	vint64 := NewAtomicUint64(conflicting, path,
+		SetAtomicRetryPolicy(NewFixedRetry(1, time.Millisecond)),
+		SetAtomicPromotedToLock(lockPath, time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := vint64.IncrementContext(ctx)
+		done <- err
+	}()
+
+	// waiting for the promotion lock
+	assert.Eventually(func() bool {
+		children, _, _ := client.backend.Children(lockPath)
+		return len(children) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(<-done, context.Canceled)
+
+	// the waiter node is removed
+	children, _, err := client.backend.Children(lockPath)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 1)
+
+	assert.Equal(holder.Release(), nil)
+	client.Disconnect()
+	conflicting.Disconnect()
+}
+
+// staleBackend reports a node as missing the first time it's read, like
+// a read made right before another client creates it
+type staleBackend struct {
+	*MemoryBackend
+	stale string
+}
+
+func (b *staleBackend) Exists(path string) (bool, *zk.Stat, error) {
+	if path == b.stale {
+		b.stale = ""
+		return false, nil, nil
+	}
+	return b.MemoryBackend.Exists(path)
+}
+
+func (b *staleBackend) Get(path string) ([]byte, *zk.Stat, error) {
+	if path == b.stale {
+		b.stale = ""
+		return nil, nil, zk.ErrNoNode
+	}
+	return b.MemoryBackend.Get(path)
+}
+
+func TestAtomicUint64CreatedMeanwhile(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/atomic/uint64/var11"
+
+	client := newTestClient()
+	_, err := NewAtomicUint64(client, path).TrySet(10)
+	assert.Equal(err, nil)
+
+	stale := NewClient(SetBackend(&staleBackend{MemoryBackend: testStore.NewBackend(), stale: path}))
+	assert.Equal(stale.Connect(), nil)
+
+	// the create fails and the value is read again
+	vint64 := NewAtomicUint64(stale, path)
+	result, err := vint64.Increment()
+	assert.Equal(err, nil)
+	assert.Equal(result, AtomicResult[uint64]{Succeeded: true, PreValue: 10, PostValue: 11, Attempts: 2})
+
+	val, _ := vint64.Get()
+	assert.Equal(val, uint64(11))
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion(path), nil)
 	client.Disconnect()
+	stale.Disconnect()
 }
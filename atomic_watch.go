@@ -0,0 +1,135 @@
+package supervisor
+
+import (
+	"context"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ValueChange change of an atomic value seen by Watch. Version is the node
+// data version after the change, -1 when the node was deleted.
+type ValueChange[T any] struct {
+	OldValue T
+	NewValue T
+	Version  int32
+}
+
+// watch sends every change of the value until ctx is done, the channel is
+// closed then. The value seen when watch returns is the first old value.
+// Watches are armed again after every event and after the connection
+// comes back, changes made meanwhile are sent as one change.
+func (av *atomicValue) watch(ctx context.Context) <-chan ValueChange[[]byte] {
+	_, _, changes := av.watchFrom(ctx)
+	return changes
+}
+
+// watchFrom is watch also returning the value seen when it returns, with
+// its version or -1 when the node doesn't exist
+func (av *atomicValue) watchFrom(ctx context.Context) ([]byte, int32, <-chan ValueChange[[]byte]) {
+	changes := make(chan ValueChange[[]byte])
+	states, unsubscribe := av.ops.subscribeConnectionState()
+
+	data, stat, channel := av.watchCurrent(nil, nil)
+	first, version := data, int32(-1)
+	if stat != nil {
+		version = stat.Version
+	}
+
+	go func() {
+		defer close(changes)
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-channel:
+			case <-states:
+			case <-ctx.Done():
+				return
+			case <-av.ops.done:
+				return
+			}
+
+			ndata, nstat, ch := av.watchCurrent(data, stat)
+			channel = ch
+			if mzxid(nstat) == mzxid(stat) {
+				continue
+			}
+
+			change := ValueChange[[]byte]{OldValue: data, NewValue: ndata, Version: -1}
+			if nstat != nil {
+				change.Version = nstat.Version
+			}
+			data, stat = ndata, nstat
+
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return first, version, changes
+}
+
+// watchCurrent reads the value and arms a watch fired when it changes,
+// stat is nil while the node doesn't exist. When reading fails the
+// previous value is kept and no watch is armed, it's tried again on the
+// next connection state change.
+func (av *atomicValue) watchCurrent(data []byte, stat *zk.Stat) ([]byte, *zk.Stat, <-chan zk.Event) {
+	exists, _, ch, err := av.ops.existsWatch(av.path)
+	if err != nil {
+		av.ops.logger.Errorf("Could not watch %s - %s", av.path, err.Error())
+		return data, stat, nil
+	}
+	if !exists {
+		return nil, nil, ch
+	}
+
+	ndata, nstat, err := av.ops.get(av.path)
+	if err == zk.ErrNoNode {
+		// deleted meanwhile, the watch fires for it
+		return nil, nil, ch
+	}
+	if err != nil {
+		av.ops.logger.Errorf("Could not watch %s - %s", av.path, err.Error())
+		return data, stat, nil
+	}
+	return ndata, nstat, ch
+}
+
+// mzxid returns the zxid of the last change, zero for missing nodes
+func mzxid(stat *zk.Stat) int64 {
+	if stat == nil {
+		return 0
+	}
+	return stat.Mzxid
+}
+
+// watchValues converts raw changes with decode, changes failing to decode
+// are logged and skipped
+func watchValues[T any](ctx context.Context, av *atomicValue, decode func([]byte) (T, error)) <-chan ValueChange[T] {
+	raw := av.watch(ctx)
+	changes := make(chan ValueChange[T])
+
+	go func() {
+		defer close(changes)
+
+		for change := range raw {
+			oldValue, err := decode(change.OldValue)
+			if err == nil {
+				var newValue T
+				if newValue, err = decode(change.NewValue); err == nil {
+					select {
+					case changes <- ValueChange[T]{OldValue: oldValue, NewValue: newValue, Version: change.Version}:
+					case <-ctx.Done():
+					}
+					continue
+				}
+			}
+			av.ops.logger.Errorf("Could not decode %s - %s", av.path, err.Error())
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,81 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtomicWatch(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/atomic/watch/var01"
+
+	watched := NewAtomicUint64(clients[0], path)
+	writer := NewAtomicUint64(clients[1], path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := watched.Watch(ctx)
+
+	_, err := writer.TrySet(10)
+	assert.Equal(err, nil)
+	change := <-changes
+	assert.Equal(change.OldValue, uint64(0))
+	assert.Equal(change.NewValue, uint64(10))
+
+	_, err = writer.Increment()
+	assert.Equal(err, nil)
+	next := <-changes
+	assert.Equal(next.OldValue, uint64(10))
+	assert.Equal(next.NewValue, uint64(11))
+	assert.Equal(next.Version, change.Version+1)
+
+	assert.Equal(clients[1].ops(nil).deleteNodeLastVersion(path), nil)
+	change = <-changes
+	assert.Equal(change.OldValue, uint64(11))
+	assert.Equal(change.Version, int32(-1))
+
+	cancel()
+	_, open := <-changes
+	assert.False(open)
+
+	closeClients(clients)
+}
+
+func TestAtomicWatchReconnect(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/atomic/watch/var02"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+	writer := newTestClient()
+
+	watched := NewAtomic[string](client, path, JSONCodec[string]{})
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := watched.Watch(ctx)
+
+	backend.Suspend()
+	_, err := NewAtomic[string](writer, path, JSONCodec[string]{}).Set("first")
+	assert.Equal(err, nil)
+	time.Sleep(20 * time.Millisecond)
+	backend.Resume()
+
+	change := <-changes
+	assert.Equal(change.OldValue, "")
+	assert.Equal(change.NewValue, "first")
+
+	// still armed after the reconnect
+	_, err = NewAtomic[string](writer, path, JSONCodec[string]{}).Set("second")
+	assert.Equal(err, nil)
+	change = <-changes
+	assert.Equal(change.OldValue, "first")
+	assert.Equal(change.NewValue, "second")
+
+	cancel()
+	assert.Equal(writer.ops(nil).deleteNodeLastVersion(path), nil)
+	client.Disconnect()
+	writer.Disconnect()
+}
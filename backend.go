@@ -0,0 +1,124 @@
+package supervisor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Backend coordination service used by the client. Every recipe goes
+// through it, so recipes can run on zookeeper or fully in-process with
+// a MemoryStore. Semantics and errors follow zookeeper.
+type Backend interface {
+	// Connect opens a session, session events (zk.EventSession) are
+	// sent to the returned channel
+	Connect(sessionTimeout time.Duration) (<-chan zk.Event, error)
+	// Close closes the session, its ephemeral nodes are removed
+	Close()
+	// SessionID returns current session id
+	SessionID() int64
+
+	Create(path string, data []byte, flags int32) (string, error)
+	CreateProtectedEphemeralSequential(path string, data []byte) (string, error)
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	// Multi applies every operation or none of them, operations are
+	// *zk.CreateRequest, *zk.SetDataRequest, *zk.DeleteRequest and
+	// *zk.CheckVersionRequest
+	Multi(ops ...interface{}) ([]zk.MultiResponse, error)
+}
+
+// SetBackend sets the backend used instead of connecting to zookeeper nodes
+func SetBackend(backend Backend) NodeOpionsFunc {
+	return func(c *Client) error {
+		c.backend = backend
+		return nil
+	}
+}
+
+// zkBackend backend using zookeeper servers
+type zkBackend struct {
+	servers []string
+	logger  Logger
+	conn    *zk.Conn
+}
+
+func (b *zkBackend) Connect(sessionTimeout time.Duration) (<-chan zk.Event, error) {
+	conn, events, err := zk.Connect(b.servers, sessionTimeout, zk.WithLogger(b.logger))
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return events, nil
+}
+
+func (b *zkBackend) Close() {
+	b.conn.Close()
+}
+
+func (b *zkBackend) SessionID() int64 {
+	return b.conn.SessionID()
+}
+
+func (b *zkBackend) Create(path string, data []byte, flags int32) (string, error) {
+	return b.conn.Create(path, data, flags, zk.WorldACL(zk.PermAll))
+}
+
+func (b *zkBackend) CreateProtectedEphemeralSequential(path string, data []byte) (string, error) {
+	return b.conn.CreateProtectedEphemeralSequential(path, data, zk.WorldACL(zk.PermAll))
+}
+
+func (b *zkBackend) Exists(path string) (bool, *zk.Stat, error) {
+	return b.conn.Exists(path)
+}
+
+func (b *zkBackend) ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	return b.conn.ExistsW(path)
+}
+
+func (b *zkBackend) Get(path string) ([]byte, *zk.Stat, error) {
+	return b.conn.Get(path)
+}
+
+func (b *zkBackend) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	return b.conn.GetW(path)
+}
+
+func (b *zkBackend) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	return b.conn.Set(path, data, version)
+}
+
+func (b *zkBackend) Delete(path string, version int32) error {
+	return b.conn.Delete(path, version)
+}
+
+func (b *zkBackend) Children(path string) ([]string, *zk.Stat, error) {
+	return b.conn.Children(path)
+}
+
+func (b *zkBackend) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	return b.conn.ChildrenW(path)
+}
+
+func (b *zkBackend) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	for _, op := range ops {
+		if create, ok := op.(*zk.CreateRequest); ok && create.Acl == nil {
+			create.Acl = zk.WorldACL(zk.PermAll)
+		}
+	}
+	return b.conn.Multi(ops...)
+}
+
+func newZkBackend(zookeeperNodes string, logger Logger) *zkBackend {
+	return &zkBackend{
+		servers: strings.Split(zookeeperNodes, ","),
+		logger:  logger,
+	}
+}
@@ -0,0 +1,331 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const doubleBarrierReadyPath = "ready"
+
+// Barrier blocks participants while the barrier node exists
+type Barrier struct {
+	client *Client
+	ops    *clientOps
+	path   string
+}
+
+// Set sets the barrier, participants calling WaitOn block until it's
+// removed
+func (b *Barrier) Set() error {
+	_, err := b.ops.createParentNodeIfNotExists(b.path, []byte{})
+	return err
+}
+
+// Remove removes the barrier, every participant waiting on it is released
+func (b *Barrier) Remove() error {
+	if err := b.ops.deleteNodeLastVersion(b.path); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", b.path, err.Error())
+	}
+	return nil
+}
+
+// WaitOn blocks until the barrier is removed or ctx is done
+func (b *Barrier) WaitOn(ctx context.Context) error {
+	if !b.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	states, unsubscribe := b.client.subscribeConnectionState()
+	defer unsubscribe()
+
+	for {
+		exists, _, channel, err := b.ops.existsWatch(b.path)
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), b.path)
+		}
+		if !exists {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-channel:
+		case <-states:
+		}
+	}
+}
+
+// BarrierOptionsFunc barrier definition
+type BarrierOptionsFunc func(*Barrier)
+
+// SetBarrierRetryPolicy overrides client retry policy for this barrier
+func SetBarrierRetryPolicy(policy RetryPolicy) BarrierOptionsFunc {
+	return func(b *Barrier) {
+		b.ops.policy = policy
+	}
+}
+
+// NewBarrier returns new barrier on path
+func NewBarrier(c *Client, path string, options ...BarrierOptionsFunc) *Barrier {
+	b := Barrier{
+		client: c,
+		ops:    c.ops(nil),
+		path:   path,
+	}
+
+	for _, option := range options {
+		option(&b)
+	}
+
+	return &b
+}
+
+// DoubleBarrier lets members start and finish together. Enter blocks until
+// memberCount members entered and Leave blocks until every member left.
+// Each member is an ephemeral node, so members whose session is gone don't
+// hold the others forever.
+type DoubleBarrier struct {
+	client      *Client
+	ops         *clientOps
+	path        string
+	memberCount int
+
+	guid     string
+	nodePath string
+
+	// readyZxid zxid that created the ready node of the current phase
+	readyZxid int64
+}
+
+// Enter blocks until memberCount members entered the barrier or ctx is
+// done. When ctx is done the member node is removed.
+func (db *DoubleBarrier) Enter(ctx context.Context) error {
+	if !db.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	if db.nodePath != "" || db.readyZxid != 0 {
+		return errors.New("Barrier [" + db.path + "] already entered")
+	}
+
+	states, unsubscribe := db.client.subscribeConnectionState()
+	defer unsubscribe()
+
+	readyPath := db.path + "/" + doubleBarrierReadyPath
+
+	// ready is watched before entering, so it's never missed
+	ready, stat, channel, err := db.ops.existsWatch(readyPath)
+	if err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), db.path)
+	}
+
+	if err := db.enqueue(); err != nil {
+		return err
+	}
+
+	for {
+		if ready {
+			db.readyZxid = stat.Czxid
+			return nil
+		}
+
+		members, err := db.members()
+		if err != nil {
+			db.remove()
+			return err
+		}
+
+		if len(members) >= db.memberCount {
+			// last member to enter releases the others
+			if _, err := db.ops.createParentNodeIfNotExists(readyPath, []byte{}); err != nil {
+				db.remove()
+				return err
+			}
+			if _, stat, err = db.ops.checkAndGetNode(readyPath); err != nil || stat == nil {
+				db.remove()
+				return fmt.Errorf("Could not read %s", readyPath)
+			}
+			db.readyZxid = stat.Czxid
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			db.remove()
+			return ctx.Err()
+		case <-channel:
+		case state := <-states:
+			if state == ConnectionStateConnected && !db.ops.ownsNode(db.nodePath) {
+				// member node went away with the session
+				if err := db.enqueue(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if ready, stat, channel, err = db.ops.existsWatch(readyPath); err != nil {
+			db.remove()
+			return fmt.Errorf("%s - %s", err.Error(), db.path)
+		}
+	}
+}
+
+// Leave blocks until every member left the barrier or ctx is done. The
+// lowest member waits for the highest one and every other member removes
+// its node and waits for the lowest one. The last member removes its node
+// and the ready node together, which ends the phase for every member, so
+// members entering the next phase never hold the ones still leaving.
+func (db *DoubleBarrier) Leave(ctx context.Context) error {
+	if db.readyZxid == 0 {
+		return errors.New("Barrier [" + db.path + "] not entered")
+	}
+
+	states, unsubscribe := db.client.subscribeConnectionState()
+	defer unsubscribe()
+
+	readyPath := db.path + "/" + doubleBarrierReadyPath
+
+	for {
+		members, err := db.members()
+		if err != nil {
+			return err
+		}
+
+		// members read while ready exists belong to this phase
+		ready, stat, readyChannel, err := db.ops.existsWatch(readyPath)
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), db.path)
+		}
+		if !ready || stat.Czxid != db.readyZxid {
+			db.remove()
+			db.readyZxid = 0
+			return nil
+		}
+
+		idx := indexOfGUID(members, db.guid)
+		if len(members) == 0 || (len(members) == 1 && idx == 0) {
+			return db.leaveLast()
+		}
+
+		var wait string
+		if idx == 0 {
+			wait = members[len(members)-1]
+		} else {
+			if idx > 0 {
+				db.remove()
+			}
+			wait = members[0]
+		}
+
+		exists, _, channel, err := db.ops.existsWatch(db.path + "/" + wait)
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), db.path)
+		}
+		if !exists {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-channel:
+		case <-readyChannel:
+		case <-states:
+		}
+	}
+}
+
+// leaveLast removes the member node, when it's still there, and the ready
+// node together
+func (db *DoubleBarrier) leaveLast() error {
+	txn := db.ops.txn()
+	if db.nodePath != "" {
+		txn.Delete(db.nodePath, -1)
+	}
+	txn.Delete(db.path+"/"+doubleBarrierReadyPath, -1)
+
+	_, err := txn.Commit()
+	if err == zk.ErrNoNode {
+		// ready removed by another member
+		err = nil
+		db.remove()
+	}
+
+	db.guid = ""
+	db.nodePath = ""
+	db.readyZxid = 0
+	return err
+}
+
+// enqueue creates the member node, together with the barrier node when
+// it's missing
+func (db *DoubleBarrier) enqueue() error {
+	abspath, guid, err := db.ops.createProtectedEphemeralSequential(db.path, []byte{})
+	if err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), db.path)
+	}
+
+	db.nodePath = abspath
+	db.guid = guid
+	return nil
+}
+
+// remove removes the member node, errors are only logged
+func (db *DoubleBarrier) remove() {
+	if db.nodePath == "" {
+		return
+	}
+
+	if err := db.ops.deleteNodeLastVersion(db.nodePath); err != nil {
+		db.client.logger.Errorf("Could not remove node %s - %s", db.nodePath, err.Error())
+	}
+	db.guid = ""
+	db.nodePath = ""
+}
+
+// members returns member nodes in the order they entered
+func (db *DoubleBarrier) members() ([]string, error) {
+	children, err := db.ops.getSortedNodeGUIDList(db.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", err.Error(), db.path)
+	}
+
+	members := children[:0]
+	for _, child := range children {
+		if child != doubleBarrierReadyPath {
+			members = append(members, child)
+		}
+	}
+	return members, nil
+}
+
+// DoubleBarrierOptionsFunc double barrier definition
+type DoubleBarrierOptionsFunc func(*DoubleBarrier)
+
+// SetDoubleBarrierRetryPolicy overrides client retry policy for this
+// double barrier
+func SetDoubleBarrierRetryPolicy(policy RetryPolicy) DoubleBarrierOptionsFunc {
+	return func(db *DoubleBarrier) {
+		db.ops.policy = policy
+	}
+}
+
+// NewDoubleBarrier returns new double barrier for memberCount members
+func NewDoubleBarrier(c *Client, path string, memberCount int, options ...DoubleBarrierOptionsFunc) *DoubleBarrier {
+	db := DoubleBarrier{
+		client:      c,
+		ops:         c.ops(nil),
+		path:        path,
+		memberCount: memberCount,
+	}
+
+	for _, option := range options {
+		option(&db)
+	}
+
+	return &db
+}
@@ -0,0 +1,93 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBarrier(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/barrier/barrier01"
+
+	barrier := NewBarrier(clients[0], path)
+	waiter := NewBarrier(clients[1], path)
+
+	// not set, nothing to wait for
+	assert.Equal(waiter.WaitOn(context.Background()), nil)
+
+	assert.Equal(barrier.Set(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	assert.Equal(waiter.WaitOn(ctx), context.DeadlineExceeded)
+	cancel()
+
+	released := make(chan error, 1)
+	go func() {
+		released <- waiter.WaitOn(context.Background())
+	}()
+
+	assert.Equal(barrier.Remove(), nil)
+	assert.Equal(<-released, nil)
+
+	closeClients(clients)
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(3)
+	path := "/supervisor/test/barrier/double01"
+
+	var entered, left int32
+	var wg sync.WaitGroup
+
+	for _, client := range clients {
+		barrier := NewDoubleBarrier(client, path, len(clients))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for phase := int32(1); phase <= 2; phase++ {
+				atomic.AddInt32(&entered, 1)
+				assert.Equal(barrier.Enter(context.Background()), nil)
+				// every member entered before anyone starts
+				assert.True(atomic.LoadInt32(&entered) >= phase*int32(len(clients)))
+
+				atomic.AddInt32(&left, 1)
+				assert.Equal(barrier.Leave(context.Background()), nil)
+				// every member finished before anyone leaves
+				assert.True(atomic.LoadInt32(&left) >= phase*int32(len(clients)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	children, err := clients[0].ops(nil).getSortedNodeGUIDList(path)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 0)
+
+	closeClients(clients)
+}
+
+func TestDoubleBarrierEnterTimeout(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(1)
+	path := "/supervisor/test/barrier/double02"
+
+	barrier := NewDoubleBarrier(clients[0], path, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	assert.Equal(barrier.Enter(ctx), context.DeadlineExceeded)
+	cancel()
+
+	children, err := clients[0].ops(nil).getSortedNodeGUIDList(path)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 0)
+	assert.NotEqual(barrier.Leave(context.Background()), nil)
+
+	closeClients(clients)
+}
@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarks follow example/mutex and example/election with many
+// participants on the same path, reporting watches set and watch events
+// delivered per round. Participants settle before each hand over, so
+// every waiter has its watch set like on a real ensemble.
+
+const benchParticipants = 50
+
+func makeBenchClients(store *MemoryStore, q int) []*Client {
+	var r []*Client
+	for i := 0; i < q; i++ {
+		c := NewClient(SetBackend(store.NewBackend()))
+		c.Connect()
+		r = append(r, c)
+	}
+	return r
+}
+
+// settle waits until no watch is set or fired for a while
+func settle(store *MemoryStore) {
+	last := store.Stats()
+	for {
+		time.Sleep(2 * time.Millisecond)
+		current := store.Stats()
+		if current == last {
+			return
+		}
+		last = current
+	}
+}
+
+func reportWatchStats(b *testing.B, store *MemoryStore) {
+	stats := store.Stats()
+	b.ReportMetric(float64(stats.Watches)/float64(b.N), "watches/op")
+	b.ReportMetric(float64(stats.Events)/float64(b.N), "events/op")
+}
+
+func BenchmarkMutexContention(b *testing.B) {
+	store := NewMemoryStore()
+	clients := makeBenchClients(store, benchParticipants)
+	defer closeClients(clients)
+
+	for n := 0; n < b.N; n++ {
+		lockPath := fmt.Sprintf("/supervisor/bench/mutex/key%d", n)
+		acquired := make(chan *Mutex)
+		var wg sync.WaitGroup
+
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				lock := NewMutex(client, lockPath)
+				if err := lock.Acquire(60, time.Second); err != nil {
+					b.Error(err)
+					return
+				}
+				acquired <- lock
+			}(client)
+		}
+
+		// every holder releases in turn once the others are waiting
+		for idx := 0; idx < len(clients); idx++ {
+			lock := <-acquired
+			settle(store)
+			lock.Release()
+		}
+		wg.Wait()
+	}
+
+	reportWatchStats(b, store)
+}
+
+// childrenWatchLock waits for the lock like Mutex and RoleSelector did
+// before, watching every node of the path, so each hand over wakes up
+// every waiter
+func childrenWatchLock(client *Client, lockPath string) (string, error) {
+	ops := client.ops(nil)
+	nodePath, guid, err := ops.createProtectedEphemeralSequential(lockPath, []byte{})
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		children, _, ch, err := ops.childrenWatch(lockPath)
+		if err != nil {
+			return "", err
+		}
+		sort.Sort(ByNodeGUID(children))
+		if len(children) > 0 && children[0] == guid {
+			return nodePath, nil
+		}
+		<-ch
+	}
+}
+
+// BenchmarkMutexContentionChildrenWatch is BenchmarkMutexContention with
+// the children watch, to compare both
+func BenchmarkMutexContentionChildrenWatch(b *testing.B) {
+	store := NewMemoryStore()
+	clients := makeBenchClients(store, benchParticipants)
+	defer closeClients(clients)
+
+	for n := 0; n < b.N; n++ {
+		lockPath := fmt.Sprintf("/supervisor/bench/childrenwatch/key%d", n)
+		acquired := make(chan string)
+		var wg sync.WaitGroup
+
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				nodePath, err := childrenWatchLock(client, lockPath)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				acquired <- nodePath
+			}(client)
+		}
+
+		for idx := 0; idx < len(clients); idx++ {
+			nodePath := <-acquired
+			settle(store)
+			clients[0].ops(nil).deleteNodeLastVersion(nodePath)
+		}
+		wg.Wait()
+	}
+
+	reportWatchStats(b, store)
+}
+
+func BenchmarkElectionFailover(b *testing.B) {
+	store := NewMemoryStore()
+	clients := makeBenchClients(store, benchParticipants)
+	defer closeClients(clients)
+
+	for n := 0; n < b.N; n++ {
+		path := fmt.Sprintf("/supervisor/bench/election/e%d", n)
+		elected := make(chan *RoleSelector)
+
+		for _, client := range clients {
+			rs := NewRoleSelector(client, path)
+			go func(rs *RoleSelector) {
+				<-rs.IsMaster
+				elected <- rs
+			}(rs)
+			rs.Start()
+		}
+
+		// every master steps down in turn until everybody was elected
+		for idx := 0; idx < len(clients); idx++ {
+			rs := <-elected
+			settle(store)
+			rs.Stop()
+		}
+	}
+
+	reportWatchStats(b, store)
+}
+
+// BenchmarkElectionFailoverChildrenWatch is BenchmarkElectionFailover with
+// the children watch, to compare both
+func BenchmarkElectionFailoverChildrenWatch(b *testing.B) {
+	store := NewMemoryStore()
+	clients := makeBenchClients(store, benchParticipants)
+	defer closeClients(clients)
+
+	for n := 0; n < b.N; n++ {
+		path := fmt.Sprintf("/supervisor/bench/electionchildrenwatch/e%d", n)
+		elected := make(chan string)
+		var wg sync.WaitGroup
+
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				nodePath, err := childrenWatchLock(client, path)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				elected <- nodePath
+			}(client)
+		}
+
+		for idx := 0; idx < len(clients); idx++ {
+			nodePath := <-elected
+			settle(store)
+			clients[0].ops(nil).deleteNodeLastVersion(nodePath)
+		}
+		wg.Wait()
+	}
+
+	reportWatchStats(b, store)
+}
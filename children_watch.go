@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"context"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// childChange child added, updated or removed, seen by watchChildren
+type childChange struct {
+	name    string
+	data    []byte
+	added   bool
+	removed bool
+}
+
+// watchChildren calls change for every child of path added, updated or
+// removed until ctx is done, the client disconnects or change returns
+// false. Children already there are sent as added first. Each child data
+// is watched once and the watch is armed again when it fires, so changes
+// between two reads are sent as one update. loaded, when set, is called
+// after every read of the children with its error. Path is waited for
+// while it doesn't exist.
+func (o *clientOps) watchChildren(ctx context.Context, path string, change func(childChange) bool, loaded func(error)) {
+	states, unsubscribe := o.subscribeConnectionState()
+	defer unsubscribe()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	versions := make(map[string]int32)
+	watched := make(map[string]bool)
+	fired := make(chan string)
+
+	for {
+		var channel <-chan zk.Event
+
+		children, _, ch, err := o.childrenWatch(path)
+		if err == zk.ErrNoNode {
+			var exists bool
+			if exists, _, ch, err = o.existsWatch(path); err == nil && exists {
+				// created meanwhile
+				continue
+			}
+		}
+		if err != nil {
+			o.logger.Errorf("Could not watch %s - %s", path, err.Error())
+		} else {
+			channel = ch
+
+			current := make(map[string]bool, len(children))
+			for _, name := range children {
+				current[name] = true
+			}
+			for name := range versions {
+				if !current[name] {
+					delete(versions, name)
+					delete(watched, name)
+					if !change(childChange{name: name, removed: true}) {
+						return
+					}
+				}
+			}
+
+			for _, name := range children {
+				if watched[name] {
+					continue
+				}
+
+				data, stat, dataChannel, err := o.getWatch(path + "/" + name)
+				if err != nil {
+					// removed meanwhile, the children watch fires for it
+					continue
+				}
+				watched[name] = true
+				go forwardChildWatch(name, dataChannel, fired, stop)
+
+				version, known := versions[name]
+				versions[name] = stat.Version
+				if known && version == stat.Version {
+					continue
+				}
+				if !change(childChange{name: name, data: data, added: !known}) {
+					return
+				}
+			}
+		}
+
+		if loaded != nil {
+			loaded(err)
+		}
+
+		select {
+		case <-channel:
+		case name := <-fired:
+			delete(watched, name)
+		case <-states:
+		case <-ctx.Done():
+			return
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// forwardChildWatch tells the watchChildren loop that the data watch of
+// name fired
+func forwardChildWatch(name string, channel <-chan zk.Event, fired chan<- string, stop <-chan struct{}) {
+	select {
+	case <-channel:
+		select {
+		case fired <- name:
+		case <-stop:
+		}
+	case <-stop:
+	}
+}
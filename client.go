@@ -1,17 +1,25 @@
 package supervisor
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// protectedPrefix prefix of protected nodes, the same used by the
+// zookeeper client
+const protectedPrefix = "_c_"
+
 var defaultClient = &Client{
-	clusterName:    "local",
 	zookeeperNodes: "127.0.0.1",
+	sessionTimeout: time.Second,
 	logger:         DefaultLogger{},
 }
 
@@ -19,15 +27,23 @@ var defaultClient = &Client{
 type Client struct {
 	clusterName    string
 	zookeeperNodes string
+	sessionTimeout time.Duration
 	logger         Logger
 
-	zkConn      *zk.Conn
+	backend     Backend
 	guid        string
 	currentRole NodeRole
+	retryPolicy RetryPolicy
 
 	currentReceiveMessageCallback NodeReceiveMessageFunc
 
-	isConnected bool
+	done chan struct{}
+
+	stateMu          sync.Mutex
+	started          bool
+	state            ConnectionState
+	stateListeners   map[int]ConnectionStateFunc
+	stateListenerSeq int
 }
 
 // NodeReceiveMessageFunc callback function when node receives message
@@ -44,6 +60,15 @@ func SetZookeeperNodes(zookeeperNodes string) NodeOpionsFunc {
 	}
 }
 
+// SetClusterName sets cluster name, every path used by the client is
+// placed under /<clusterName>. Empty name uses paths as they are.
+func SetClusterName(clusterName string) NodeOpionsFunc {
+	return func(c *Client) error {
+		c.clusterName = clusterName
+		return nil
+	}
+}
+
 // SetLogger logger
 func SetLogger(l Logger) NodeOpionsFunc {
 	return func(c *Client) error {
@@ -60,71 +85,266 @@ func SetNodeReceiveMessageCallback(receiveMessageCb NodeReceiveMessageFunc) Node
 	}
 }
 
-// Connect connects to zookeeper
+// Connect connects to zookeeper and waits until the session is established
+// or the session timeout elapses
 func (c *Client) Connect() error {
-	var err error
-
-	c.zkConn, _, err = zk.Connect(strings.Split(c.zookeeperNodes, ","), time.Second)
+	events, err := c.backend.Connect(c.sessionTimeout)
 	if err != nil {
 		return err
 	}
 
-	c.zkConn.SetLogger(c.logger)
-	c.isConnected = true
+	c.done = make(chan struct{})
+	connected := make(chan struct{})
+	go c.watchSession(events, connected)
+
+	select {
+	case <-connected:
+	case <-time.After(c.sessionTimeout * 10):
+		c.backend.Close()
+		return errors.New("Timeout connecting to " + c.zookeeperNodes)
+	}
+
+	if namespace, ok := c.backend.(*namespaceBackend); ok {
+		if err := namespace.createNamespace(); err != nil {
+			c.backend.Close()
+			return err
+		}
+	}
+
+	if c.guid == "" {
+		if c.guid, err = newNodeGUID(); err != nil {
+			c.backend.Close()
+			return err
+		}
+	}
+
+	if c.currentReceiveMessageCallback != nil {
+		if err := c.registerInbox(); err != nil {
+			c.backend.Close()
+			return err
+		}
+	}
+
+	c.stateMu.Lock()
+	c.started = true
+	c.stateMu.Unlock()
+
+	if c.currentReceiveMessageCallback != nil {
+		go c.receiveMessages()
+	}
 
 	return nil
 }
 
-func (c *Client) checkAndGetNode(path string) ([]byte, *zk.Stat, error) {
-	if exists, _, err := c.zkConn.Exists(path); err != nil || !exists {
-		return nil, nil, err
+// clientOps runs zookeeper calls, retrying the ones failing because of
+// the connection with policy. Recipes hold their own to override the
+// client retry policy.
+type clientOps struct {
+	*Client
+	policy RetryPolicy
+	ctx    context.Context
+}
+
+// ops returns zookeeper calls using policy, nil uses client retry policy
+func (c *Client) ops(policy RetryPolicy) *clientOps {
+	return &clientOps{Client: c, policy: policy}
+}
+
+// withContext returns the same calls, retries stop when ctx is done
+func (o *clientOps) withContext(ctx context.Context) *clientOps {
+	ops := *o
+	ops.ctx = ctx
+	return &ops
+}
+
+// ctxDone returns ctx done channel, nil blocks forever without ctx
+func (o *clientOps) ctxDone() <-chan struct{} {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Done()
+}
+
+func (o *clientOps) retryPolicy() RetryPolicy {
+	if o.policy != nil {
+		return o.policy
+	}
+	if o.Client.retryPolicy != nil {
+		return o.Client.retryPolicy
 	}
+	return defaultRetryPolicy
+}
+
+// retry calls fn until it succeeds, fails with an error not caused by the
+// connection, the policy gives up, the client disconnects or ctx is done
+func (o *clientOps) retry(fn func() error) error {
+	policy := o.retryPolicy()
+	start := time.Now()
+
+	for retries := 1; ; retries++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || o.disconnecting() {
+			return err
+		}
+
+		sleep, ok := policy.AllowRetry(retries, time.Since(start))
+		if !ok {
+			return err
+		}
+
+		o.logger.Debugf("Retrying in %s, retry %d - %s", sleep, retries, err.Error())
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-o.done:
+			timer.Stop()
+			return err
+		case <-o.ctxDone():
+			timer.Stop()
+			return o.ctx.Err()
+		}
+	}
+}
+
+func (o *clientOps) checkAndGetNode(path string) (data []byte, stat *zk.Stat, err error) {
+	err = o.retry(func() error {
+		var exists bool
+		if exists, _, err = o.backend.Exists(path); err != nil || !exists {
+			data, stat = nil, nil
+			return err
+		}
 
-	data, stat, err := c.zkConn.Get(path)
+		data, stat, err = o.backend.Get(path)
+		return err
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-
 	return data, stat, nil
 }
 
-func (c *Client) setNodeData(path string, data []byte, version int32) (*zk.Stat, error) {
-	return c.zkConn.Set(path, data, version)
+func (o *clientOps) get(path string) (data []byte, stat *zk.Stat, err error) {
+	err = o.retry(func() error {
+		data, stat, err = o.backend.Get(path)
+		return err
+	})
+	return data, stat, err
 }
 
-func (c *Client) createNodeIfNotExists(path string, data []byte) (bool, error) {
-	exists, _, err := c.zkConn.Exists(path)
-	if err != nil {
-		return false, err
+func (o *clientOps) getWatch(path string) (data []byte, stat *zk.Stat, ch <-chan zk.Event, err error) {
+	err = o.retry(func() error {
+		data, stat, ch, err = o.backend.GetW(path)
+		return err
+	})
+	return data, stat, ch, err
+}
+
+// setNodeData isn't retried when version is set, a write that went
+// through before the connection failed would be reported as a conflict
+func (o *clientOps) setNodeData(path string, data []byte, version int32) (stat *zk.Stat, err error) {
+	if version != -1 {
+		return o.backend.Set(path, data, version)
 	}
 
-	if !exists {
-		if _, err := c.zkConn.Create(path, data, 0, zk.WorldACL(zk.PermAll)); err != nil {
-			return false, err
+	err = o.retry(func() error {
+		stat, err = o.backend.Set(path, data, version)
+		return err
+	})
+	return stat, err
+}
+
+// createParentNodeIfNotExists creates path with data, missing parents are
+// created in the same transaction. When another client creates some of
+// them meanwhile it looks for missing nodes again.
+func (o *clientOps) createParentNodeIfNotExists(path string, data []byte) (bool, error) {
+	err := o.retry(func() error {
+		for {
+			missing, err := o.missingNodes(path)
+			if err != nil || len(missing) == 0 {
+				return err
+			}
+
+			txn := o.txn()
+			for _, node := range missing {
+				if node == path {
+					txn.Create(node, data, 0)
+				} else {
+					txn.Create(node, []byte{}, 0)
+				}
+			}
+
+			if _, err := txn.Commit(); err != zk.ErrNodeExists {
+				return err
+			}
 		}
-	}
-	return true, nil
+	})
+	return err == nil, err
 }
 
-func (c *Client) createParentNodeIfNotExists(path string, data []byte) (bool, error) {
-	parts := strings.Split(path, "/")
-	lparts := len(parts)
-	current := ""
+// createNode creates path with data, missing parents are created in the
+// same transaction. It fails with zk.ErrNodeExists when path exists, so
+// callers deciding on what they read can read again. Like versioned sets
+// the create isn't retried, it could fail on a new attempt after going
+// through.
+func (o *clientOps) createNode(path string, data []byte) error {
+	for {
+		var missing []string
+		err := o.retry(func() (err error) {
+			missing, err = o.missingNodes(path)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			return zk.ErrNodeExists
+		}
 
-	if lparts > 1 {
-		for idx := 0; idx < lparts-1; idx++ {
-			current += parts[idx]
-			c.createNodeIfNotExists(current, []byte{})
-			current += "/"
+		txn := o.txn()
+		for _, node := range missing[:len(missing)-1] {
+			txn.Create(node, []byte{}, 0)
 		}
+		txn.Create(path, data, 0)
+
+		results, err := txn.Commit()
+		if err == zk.ErrNodeExists && len(results) > 0 && results[len(results)-1].Err == zk.ErrNodeExists {
+			return err
+		}
+		if err != zk.ErrNodeExists && err != zk.ErrNoNode {
+			return err
+		}
+		// parents created or removed by another client meanwhile
 	}
+}
 
-	current += parts[lparts-1]
-	return c.createNodeIfNotExists(current, data)
+// missingNodes returns path and its parents that don't exist, parents first
+func (o *clientOps) missingNodes(path string) ([]string, error) {
+	var missing []string
+
+	for current := path; current != "" && current != "/"; {
+		exists, _, err := o.backend.Exists(current)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			break
+		}
+		missing = append([]string{current}, missing...)
+
+		idx := strings.LastIndex(current, "/")
+		if idx < 0 {
+			break
+		}
+		current = current[:idx]
+	}
+	return missing, nil
 }
 
-func (c *Client) getSortedNodeGUIDList(path string) ([]string, error) {
-	nodeListGUID, _, _, err := c.zkConn.ChildrenW(path)
+func (o *clientOps) getSortedNodeGUIDList(path string) (nodeListGUID []string, err error) {
+	err = o.retry(func() error {
+		nodeListGUID, _, err = o.backend.Children(path)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -132,63 +352,183 @@ func (c *Client) getSortedNodeGUIDList(path string) ([]string, error) {
 	return nodeListGUID, nil
 }
 
-func (c *Client) createProtectedEphemeralSequential(path string, data []byte) (string, string, error) {
-	npath, err := c.zkConn.CreateProtectedEphemeralSequential(path+"/", data, zk.WorldACL(zk.PermAll))
+func (o *clientOps) createProtectedEphemeralSequential(path string, data []byte) (string, string, error) {
+	return o.createProtectedEphemeralSequentialNamed(path, "", data)
+}
+
+// createProtectedEphemeralSequentialNamed same as createProtectedEphemeralSequential,
+// name is placed between the protected prefix and the sequence. Missing
+// parents are created in the same transaction as the node. The node
+// creation isn't retried here, the backend already looks for the protected
+// node after a connection failure and a new attempt could leave an orphan
+// node queued.
+func (o *clientOps) createProtectedEphemeralSequentialNamed(path, name string, data []byte) (string, string, error) {
+	for {
+		var missing []string
+		err := o.retry(func() (err error) {
+			missing, err = o.missingNodes(path)
+			return err
+		})
+		if err != nil {
+			return "", "", err
+		}
+
+		var npath string
+		if len(missing) == 0 {
+			npath, err = o.backend.CreateProtectedEphemeralSequential(path+"/"+name, data)
+		} else {
+			npath, err = o.createWithParents(missing, path+"/"+name, data)
+		}
+		if err == zk.ErrNodeExists || err == zk.ErrNoNode {
+			// parents created or removed by another client meanwhile
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		guid := npath[len(path)+1:]
+		return npath, guid, nil
+	}
+}
+
+// createWithParents creates missing parents and the protected node in one
+// transaction
+func (o *clientOps) createWithParents(missing []string, path string, data []byte) (string, error) {
+	protected, err := protectedPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	txn := o.txn()
+	for _, node := range missing {
+		txn.Create(node, []byte{}, 0)
+	}
+	txn.Create(protected, data, zk.FlagEphemeral|zk.FlagSequence)
+
+	results, err := txn.Commit()
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
-	guid := npath[len(path)+1:]
-	return npath, guid, nil
+	return results[len(results)-1].Path, nil
 }
 
-func (c *Client) childrenWatch(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
-	return c.zkConn.ChildrenW(path)
+// protectedPath adds to the node name the protected prefix used by the
+// zookeeper client, so the node can be found after a connection failure
+func protectedPath(path string) (string, error) {
+	var guid [16]byte
+	if _, err := rand.Read(guid[:]); err != nil {
+		return "", err
+	}
+
+	idx := strings.LastIndex(path, "/")
+	return fmt.Sprintf("%s/%s%x-%s", path[:idx], protectedPrefix, guid, path[idx+1:]), nil
 }
 
-func (c *Client) deleteBaseNode(path string) error {
-	parts := strings.Split(strings.TrimLeft(path, "/"), "/")
-	lparts := len(parts)
+func (o *clientOps) childrenWatch(path string) (children []string, stat *zk.Stat, ch <-chan zk.Event, err error) {
+	err = o.retry(func() error {
+		children, stat, ch, err = o.backend.ChildrenW(path)
+		return err
+	})
+	return children, stat, ch, err
+}
 
-	for idx := 0; idx < lparts; idx++ {
-		curr := "/" + strings.Join(parts[:lparts-idx], "/")
+func (o *clientOps) existsWatch(path string) (exists bool, stat *zk.Stat, ch <-chan zk.Event, err error) {
+	err = o.retry(func() error {
+		exists, stat, ch, err = o.backend.ExistsW(path)
+		return err
+	})
+	return exists, stat, ch, err
+}
+
+// deleteBaseNode removes path and its parents left empty in one
+// transaction. When another client adds children meanwhile it looks for
+// empty nodes again.
+func (o *clientOps) deleteBaseNode(path string) error {
+	return o.retry(func() error {
+		for {
+			txn := o.txn()
+			child := ""
+
+			for current := path; current != "" && current != "/"; {
+				children, stat, err := o.backend.Children(current)
+				if err != nil && err != zk.ErrNoNode {
+					return err
+				}
+				if err == nil {
+					if len(children) > 1 || (len(children) == 1 && children[0] != child) {
+						break
+					}
+					txn.Delete(current, stat.Version)
+				}
+
+				idx := strings.LastIndex(current, "/")
+				if idx < 0 {
+					break
+				}
+				child, current = current[idx+1:], current[:idx]
+			}
+
+			_, err := txn.Commit()
+			if err != zk.ErrNotEmpty && err != zk.ErrBadVersion && err != zk.ErrNoNode {
+				return err
+			}
+		}
+	})
+}
 
-		nodeGUIDList, err := c.getSortedNodeGUIDList(curr)
+// ownsNode returns true when path exists and belongs to the current session
+func (o *clientOps) ownsNode(path string) bool {
+	var exists bool
+	var stat *zk.Stat
+	err := o.retry(func() (err error) {
+		exists, stat, err = o.backend.Exists(path)
+		return err
+	})
+	return err == nil && exists && stat.EphemeralOwner == o.backend.SessionID()
+}
+
+func (o *clientOps) deleteNode(path string, version int32) error {
+	return o.retry(func() error {
+		return o.backend.Delete(path, version)
+	})
+}
+
+func (o *clientOps) deleteNodeLastVersion(path string) error {
+	return o.retry(func() error {
+		exists, stat, err := o.backend.Exists(path)
 		if err != nil {
 			return err
 		}
 
-		if len(nodeGUIDList) > 0 {
+		if !exists {
 			return nil
 		}
 
-		if err := c.deleteNodeLastVersion(curr); err != nil {
-			return fmt.Errorf("Can't remove %s: %s", curr, err.Error())
-		}
-	}
-
-	return nil
-}
-
-func (c *Client) deleteNode(path string, version int32) error {
-	return c.zkConn.Delete(path, version)
+		return o.backend.Delete(path, stat.Version)
+	})
 }
 
-func (c *Client) deleteNodeLastVersion(path string) error {
-	exists, stat, err := c.zkConn.Exists(path)
-	if err != nil {
-		return err
+// Disconnect disconect from zk servers, it does nothing when the client
+// isn't connected
+func (c *Client) Disconnect() {
+	c.stateMu.Lock()
+	if !c.started {
+		c.stateMu.Unlock()
+		return
 	}
+	c.started = false
+	c.stateMu.Unlock()
 
-	if !exists {
-		return nil
-	}
+	close(c.done)
 
-	return c.zkConn.Delete(path, stat.Version)
-}
+	if c.currentReceiveMessageCallback != nil {
+		if err := c.removeInbox(); err != nil {
+			c.logger.Errorf("Could not remove inbox - %s", err.Error())
+		}
+	}
 
-// Disconnect disconect from zk servers
-func (c *Client) Disconnect() {
-	c.zkConn.Close()
+	c.backend.Close()
 }
 
 // NewClient creates new Supervisor client
@@ -197,6 +537,7 @@ func NewClient(options ...NodeOpionsFunc) *Client {
 		clusterName:    defaultClient.clusterName,
 		currentRole:    NodeRoleSlave,
 		zookeeperNodes: defaultClient.zookeeperNodes,
+		sessionTimeout: defaultClient.sessionTimeout,
 		logger:         defaultClient.logger,
 	}
 
@@ -204,5 +545,14 @@ func NewClient(options ...NodeOpionsFunc) *Client {
 		option(n)
 	}
 
+	if n.backend == nil {
+		n.backend = newZkBackend(n.zookeeperNodes, n.logger)
+	}
+
+	// wrapped once, Connect is called again after Disconnect
+	if strings.Trim(n.clusterName, "/") != "" {
+		n.backend = newNamespaceBackend(n.backend, n.clusterName)
+	}
+
 	return n
 }
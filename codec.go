@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Codec converts values stored by Atomic to and from node data
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec stores values as JSON
+type JSONCodec[T any] struct{}
+
+// Encode encodes v as JSON
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes JSON data
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// YAMLCodec stores values as YAML, handy for configs edited by hand
+type YAMLCodec[T any] struct{}
+
+// Encode encodes v as YAML
+func (YAMLCodec[T]) Encode(v T) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// Decode decodes YAML data
+func (YAMLCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := yaml.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec stores values with encoding/gob. Gob doesn't sort maps, so
+// CompareAndSet on values holding maps may fail even when equal.
+type GobCodec[T any] struct{}
+
+// Encode encodes v with gob
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes gob data
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// ProtoMessage message able to marshal itself to protobuf wire format,
+// like the ones generated by gogo/protobuf
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// protoPointer pointer to T implementing ProtoMessage
+type protoPointer[T any] interface {
+	*T
+	ProtoMessage
+}
+
+// ProtoCodec stores messages in protobuf wire format
+type ProtoCodec[T any, PT protoPointer[T]] struct{}
+
+// NewProtoCodec returns protobuf codec for messages of type *T, use it
+// as NewProtoCodec[pb.Config]() with Atomic[*pb.Config]
+func NewProtoCodec[T any, PT protoPointer[T]]() ProtoCodec[T, PT] {
+	return ProtoCodec[T, PT]{}
+}
+
+// Encode encodes message to protobuf wire format
+func (ProtoCodec[T, PT]) Encode(v PT) ([]byte, error) {
+	if v == nil {
+		return []byte{}, nil
+	}
+	return v.Marshal()
+}
+
+// Decode decodes protobuf wire format data into a new message
+func (ProtoCodec[T, PT]) Decode(data []byte) (PT, error) {
+	v := PT(new(T))
+	if err := v.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// RawCodec stores bytes as they are
+type RawCodec struct{}
+
+// Encode returns v
+func (RawCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// Decode returns data
+func (RawCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
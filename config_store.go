@@ -0,0 +1,386 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	// ErrConfigNotFound returned when a config doesn't exist
+	ErrConfigNotFound = errors.New("Config not found")
+	// ErrConfigVersionConflict returned when a config was changed since the
+	// version given was read
+	ErrConfigVersionConflict = errors.New("Config version conflict")
+)
+
+// ConfigChange new config delivered to OnChange callbacks. OldValue is the
+// previous good config, zero value for the first one.
+type ConfigChange[T any] struct {
+	Name     string
+	OldValue T
+	NewValue T
+	Version  int32
+}
+
+// ConfigStore typed configs kept in a tree under a path, each config is a
+// node named after it. Names may hold "/" to nest configs. Watched configs
+// are reloaded when they change and the last good config is kept when a
+// change fails to decode or validate.
+type ConfigStore[T any] struct {
+	client   *Client
+	ops      *clientOps
+	path     string
+	codec    Codec[T]
+	validate func(T) error
+	rollback bool
+
+	mu       sync.Mutex
+	watches  map[string]*configWatch[T]
+	callback int
+}
+
+// configWatch last good config of a watched name and its callbacks
+type configWatch[T any] struct {
+	loaded    bool
+	value     T
+	data      []byte
+	version   int32
+	callbacks map[int]func(ConfigChange[T])
+	cancel    context.CancelFunc
+}
+
+// Get returns config name with its version
+func (cs *ConfigStore[T]) Get(name string) (T, int32, error) {
+	var zero T
+
+	path := cs.configPath(name)
+	data, stat, err := cs.ops.checkAndGetNode(path)
+	if err != nil {
+		return zero, 0, err
+	}
+	if stat == nil {
+		return zero, 0, ErrConfigNotFound
+	}
+
+	value, err := cs.decode(data)
+	if err != nil {
+		return zero, 0, fmt.Errorf("%s - %s", err.Error(), path)
+	}
+	return value, stat.Version, nil
+}
+
+// Set validates and saves config name, returning its new version. Unless
+// version is -1 the config is only saved when it still has that version,
+// ErrConfigVersionConflict is returned otherwise. With -1 the config is
+// created when it doesn't exist.
+func (cs *ConfigStore[T]) Set(name string, value T, version int32) (int32, error) {
+	if !cs.client.isConnected() {
+		return 0, errors.New("Client not connected")
+	}
+
+	if cs.validate != nil {
+		if err := cs.validate(value); err != nil {
+			return 0, err
+		}
+	}
+
+	data, err := cs.codec.Encode(value)
+	if err != nil {
+		return 0, err
+	}
+
+	path := cs.configPath(name)
+	for {
+		stat, err := cs.ops.setNodeData(path, data, version)
+		switch {
+		case err == nil:
+			return stat.Version, nil
+		case err == zk.ErrBadVersion:
+			return 0, ErrConfigVersionConflict
+		case err != zk.ErrNoNode:
+			return 0, fmt.Errorf("%s - %s", err.Error(), path)
+		case version != -1:
+			return 0, ErrConfigNotFound
+		}
+
+		if _, err := cs.ops.createParentNodeIfNotExists(path[:strings.LastIndex(path, "/")], []byte{}); err != nil {
+			return 0, err
+		}
+		// not retried, set again when another client created it meanwhile
+		if _, err := cs.ops.backend.Create(path, data, 0); err != zk.ErrNodeExists {
+			if err != nil {
+				return 0, fmt.Errorf("%s - %s", err.Error(), path)
+			}
+			return 0, nil
+		}
+	}
+}
+
+// Delete removes config name. Unless version is -1 it's only removed when
+// it still has that version.
+func (cs *ConfigStore[T]) Delete(name string, version int32) error {
+	path := cs.configPath(name)
+
+	err := cs.ops.deleteNode(path, version)
+	switch err {
+	case nil:
+		return nil
+	case zk.ErrNoNode:
+		return ErrConfigNotFound
+	case zk.ErrBadVersion:
+		return ErrConfigVersionConflict
+	}
+	return fmt.Errorf("Could not remove node %s - %s", path, err.Error())
+}
+
+// Names returns the names of every config in the tree, nodes holding no
+// data are only parents
+func (cs *ConfigStore[T]) Names() ([]string, error) {
+	names := []string{}
+	if err := cs.walk("", &names); err != nil && err != zk.ErrNoNode {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walk appends names of configs below name
+func (cs *ConfigStore[T]) walk(name string, names *[]string) error {
+	path := cs.path
+	if name != "" {
+		path = cs.configPath(name)
+	}
+
+	children, err := cs.ops.getSortedNodeGUIDList(path)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if name != "" {
+			child = name + "/" + child
+		}
+
+		data, _, err := cs.ops.get(cs.configPath(child))
+		if err == zk.ErrNoNode {
+			// removed meanwhile
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), cs.configPath(child))
+		}
+		if len(data) > 0 {
+			*names = append(*names, child)
+		}
+
+		if err := cs.walk(child, names); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnChange calls fn with every new good config name until the returned
+// function is called. The current config is loaded first and returned,
+// ErrConfigNotFound while there is no good one yet. Configs failing to
+// decode or validate are logged and skipped, the last good one is kept.
+func (cs *ConfigStore[T]) OnChange(name string, fn func(ConfigChange[T])) (T, func(), error) {
+	var zero T
+
+	if !cs.client.isConnected() {
+		return zero, nil, errors.New("Client not connected")
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cw, ok := cs.watches[name]
+	if !ok {
+		cw = cs.watch(name)
+		cs.watches[name] = cw
+	}
+
+	cs.callback++
+	id := cs.callback
+	cw.callbacks[id] = fn
+
+	remove := func() {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+
+		delete(cw.callbacks, id)
+		if len(cw.callbacks) == 0 && cs.watches[name] == cw {
+			cw.cancel()
+			delete(cs.watches, name)
+		}
+	}
+
+	if !cw.loaded {
+		return zero, remove, ErrConfigNotFound
+	}
+	return cw.value, remove, nil
+}
+
+// Current returns the last good config name, only known for configs
+// watched with OnChange
+func (cs *ConfigStore[T]) Current(name string) (T, int32, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cw, ok := cs.watches[name]
+	if !ok || !cw.loaded {
+		var zero T
+		return zero, 0, false
+	}
+	return cw.value, cw.version, true
+}
+
+// watch loads config name and starts following its changes, it's called
+// holding mu
+func (cs *ConfigStore[T]) watch(name string) *configWatch[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+	cw := configWatch[T]{
+		callbacks: make(map[int]func(ConfigChange[T])),
+		cancel:    cancel,
+	}
+
+	av := &atomicValue{ops: cs.ops, path: cs.configPath(name)}
+	data, version, changes := av.watchFrom(ctx)
+	if version != -1 {
+		if value, err := cs.decode(data); err == nil {
+			cw.loaded = true
+			cw.value, cw.data, cw.version = value, data, version
+		} else {
+			cs.client.logger.Errorf("Could not load config %s - %s", av.path, err.Error())
+		}
+	}
+
+	go func() {
+		for change := range changes {
+			cs.reload(name, &cw, change)
+		}
+	}()
+
+	return &cw
+}
+
+// reload replaces the config of cw with change when it's good and calls
+// callbacks with it. Otherwise the last good config is kept and, with
+// rollback, saved again unless the config changed meanwhile.
+func (cs *ConfigStore[T]) reload(name string, cw *configWatch[T], change ValueChange[[]byte]) {
+	path := cs.configPath(name)
+
+	if change.Version == -1 {
+		cs.client.logger.Errorf("Config %s removed, last good config kept", path)
+		return
+	}
+
+	cs.mu.Lock()
+	if cw.loaded && bytes.Equal(change.NewValue, cw.data) {
+		// saved again, by a rollback for instance
+		cw.version = change.Version
+		cs.mu.Unlock()
+		return
+	}
+	cs.mu.Unlock()
+
+	value, err := cs.decode(change.NewValue)
+	if err != nil {
+		cs.client.logger.Errorf("Could not load config %s - %s", path, err.Error())
+
+		cs.mu.Lock()
+		data, loaded := cw.data, cw.loaded
+		cs.mu.Unlock()
+
+		if cs.rollback && loaded {
+			if _, err := cs.ops.setNodeData(path, data, change.Version); err != nil && err != zk.ErrBadVersion {
+				cs.client.logger.Errorf("Could not roll back config %s - %s", path, err.Error())
+			}
+		}
+		return
+	}
+
+	cs.mu.Lock()
+	update := ConfigChange[T]{Name: name, OldValue: cw.value, NewValue: value, Version: change.Version}
+	cw.loaded = true
+	cw.value, cw.data, cw.version = value, change.NewValue, change.Version
+
+	callbacks := make([]func(ConfigChange[T]), 0, len(cw.callbacks))
+	for _, fn := range cw.callbacks {
+		callbacks = append(callbacks, fn)
+	}
+	cs.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(update)
+	}
+}
+
+// decode decodes and validates data
+func (cs *ConfigStore[T]) decode(data []byte) (T, error) {
+	value, err := cs.codec.Decode(data)
+	if err != nil {
+		return value, err
+	}
+	if cs.validate != nil {
+		if err := cs.validate(value); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+func (cs *ConfigStore[T]) configPath(name string) string {
+	return cs.path + "/" + name
+}
+
+// ConfigStoreOptionsFunc config store definition
+type ConfigStoreOptionsFunc[T any] func(*ConfigStore[T])
+
+// SetConfigValidator rejects configs for which validate returns an error,
+// both when they are saved and when they are loaded
+func SetConfigValidator[T any](validate func(T) error) ConfigStoreOptionsFunc[T] {
+	return func(cs *ConfigStore[T]) {
+		cs.validate = validate
+	}
+}
+
+// SetConfigRollback saves the last good config again when a watched config
+// changes to one failing to decode or validate
+func SetConfigRollback[T any]() ConfigStoreOptionsFunc[T] {
+	return func(cs *ConfigStore[T]) {
+		cs.rollback = true
+	}
+}
+
+// SetConfigStoreRetryPolicy overrides client retry policy for this store
+func SetConfigStoreRetryPolicy[T any](policy RetryPolicy) ConfigStoreOptionsFunc[T] {
+	return func(cs *ConfigStore[T]) {
+		cs.ops.policy = policy
+	}
+}
+
+// NewConfigStore returns new store of configs under path, codec converts
+// them to and from node data
+func NewConfigStore[T any](c *Client, path string, codec Codec[T], options ...ConfigStoreOptionsFunc[T]) *ConfigStore[T] {
+	cs := ConfigStore[T]{
+		client:  c,
+		ops:     c.ops(nil),
+		path:    path,
+		codec:   codec,
+		watches: make(map[string]*configWatch[T]),
+	}
+
+	for _, option := range options {
+		option(&cs)
+	}
+
+	return &cs
+}
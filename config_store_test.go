@@ -0,0 +1,166 @@
+package supervisor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type storeTestConfig struct {
+	Servers []string
+	Timeout int
+}
+
+func validateStoreTestConfig(c storeTestConfig) error {
+	if c.Timeout <= 0 {
+		return errors.New("Timeout must be positive")
+	}
+	return nil
+}
+
+func TestConfigStore(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/config/store01"
+
+	store := NewConfigStore[storeTestConfig](clients[0], path, JSONCodec[storeTestConfig]{},
+		SetConfigValidator(validateStoreTestConfig))
+	other := NewConfigStore[storeTestConfig](clients[1], path, JSONCodec[storeTestConfig]{})
+
+	_, _, err := store.Get("db")
+	assert.Equal(err, ErrConfigNotFound)
+
+	names, err := store.Names()
+	assert.Equal(err, nil)
+	assert.Equal(len(names), 0)
+
+	db := storeTestConfig{Servers: []string{"10.0.0.1"}, Timeout: 5}
+	version, err := store.Set("db", db, -1)
+	assert.Equal(err, nil)
+	assert.Equal(version, int32(0))
+
+	_, err = store.Set("db", storeTestConfig{}, -1)
+	assert.NotEqual(err, nil)
+
+	_, err = store.Set("services/api", storeTestConfig{Timeout: 1}, -1)
+	assert.Equal(err, nil)
+
+	names, err = other.Names()
+	assert.Equal(err, nil)
+	assert.Equal(names, []string{"db", "services/api"})
+
+	value, version, err := other.Get("db")
+	assert.Equal(err, nil)
+	assert.Equal(value, db)
+	assert.Equal(version, int32(0))
+
+	// versioned writes fail once another client saved a newer version
+	db.Timeout = 10
+	version, err = other.Set("db", db, version)
+	assert.Equal(err, nil)
+	assert.Equal(version, int32(1))
+	_, err = store.Set("db", db, 0)
+	assert.Equal(err, ErrConfigVersionConflict)
+
+	_, err = store.Set("cache", db, 3)
+	assert.Equal(err, ErrConfigNotFound)
+
+	assert.Equal(store.Delete("services/api", 5), ErrConfigVersionConflict)
+	assert.Equal(store.Delete("services/api", 0), nil)
+	assert.Equal(store.Delete("services/api", -1), ErrConfigNotFound)
+
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
+
+func TestConfigStoreYAML(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/config/store03"
+
+	store := NewConfigStore[storeTestConfig](clients[0], path, YAMLCodec[storeTestConfig]{})
+	other := NewConfigStore[storeTestConfig](clients[1], path, YAMLCodec[storeTestConfig]{})
+
+	db := storeTestConfig{Servers: []string{"10.0.0.1", "10.0.0.2"}, Timeout: 5}
+	_, err := store.Set("db", db, -1)
+	assert.Equal(err, nil)
+
+	data, _, err := clients[0].ops(nil).get(path + "/db")
+	assert.Equal(err, nil)
+	assert.True(strings.Contains(string(data), "timeout: 5"))
+
+	value, version, err := other.Get("db")
+	assert.Equal(err, nil)
+	assert.Equal(value, db)
+	assert.Equal(version, int32(0))
+
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
+
+func TestConfigStoreOnChange(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/config/store02"
+
+	watcher := NewConfigStore[storeTestConfig](clients[0], path, JSONCodec[storeTestConfig]{},
+		SetConfigValidator(validateStoreTestConfig), SetConfigRollback[storeTestConfig]())
+	writer := NewConfigStore[storeTestConfig](clients[1], path, JSONCodec[storeTestConfig]{})
+
+	_, remove, err := watcher.OnChange("db", func(ConfigChange[storeTestConfig]) {})
+	assert.Equal(err, ErrConfigNotFound)
+	remove()
+
+	v1 := storeTestConfig{Servers: []string{"10.0.0.1"}, Timeout: 5}
+	_, err = writer.Set("db", v1, -1)
+	assert.Equal(err, nil)
+
+	changes := make(chan ConfigChange[storeTestConfig], 10)
+	current, remove, err := watcher.OnChange("db", func(change ConfigChange[storeTestConfig]) {
+		changes <- change
+	})
+	assert.Equal(err, nil)
+	assert.Equal(current, v1)
+
+	v2 := storeTestConfig{Servers: []string{"10.0.0.2"}, Timeout: 5}
+	_, err = writer.Set("db", v2, -1)
+	assert.Equal(err, nil)
+	assert.Equal(<-changes, ConfigChange[storeTestConfig]{Name: "db", OldValue: v1, NewValue: v2, Version: 1})
+
+	// invalid config is rolled back to the last good one
+	_, err = writer.Set("db", storeTestConfig{Timeout: -1}, -1)
+	assert.Equal(err, nil)
+	assert.Eventually(func() bool {
+		value, version, err := writer.Get("db")
+		return err == nil && value.Timeout == 5 && version == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// data failing to decode is rolled back too
+	_, err = clients[1].backend.Set(path+"/db", []byte("{"), -1)
+	assert.Equal(err, nil)
+	assert.Eventually(func() bool {
+		value, version, err := writer.Get("db")
+		return err == nil && reflect.DeepEqual(value, v2) && version == 5
+	}, time.Second, 10*time.Millisecond)
+
+	value, _, ok := watcher.Current("db")
+	assert.True(ok)
+	assert.Equal(value, v2)
+
+	v3 := storeTestConfig{Servers: []string{"10.0.0.3"}, Timeout: 5}
+	_, err = writer.Set("db", v3, -1)
+	assert.Equal(err, nil)
+	assert.Equal(<-changes, ConfigChange[storeTestConfig]{Name: "db", OldValue: v2, NewValue: v3, Version: 6})
+	assert.Equal(len(changes), 0)
+
+	remove()
+	_, _, ok = watcher.Current("db")
+	assert.False(ok)
+
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
@@ -0,0 +1,206 @@
+package supervisor
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// ConnectionStateConnected a new session was established, either
+	// on the first connect or after the previous session expired
+	ConnectionStateConnected ConnectionState = 1
+
+	// ConnectionStateSuspended connection to zookeeper was lost but the
+	// session may still be alive
+	ConnectionStateSuspended ConnectionState = 2
+
+	// ConnectionStateReconnected connection was re-established and the
+	// session (and its ephemeral nodes) survived
+	ConnectionStateReconnected ConnectionState = 3
+
+	// ConnectionStateLost client was suspended for longer than the session
+	// timeout, the session must be considered gone
+	ConnectionStateLost ConnectionState = 4
+
+	// ConnectionStateExpired zookeeper expired the session, every ephemeral
+	// node and watch created by it is gone
+	ConnectionStateExpired ConnectionState = 5
+)
+
+// ConnectionState client connection state
+type ConnectionState int32
+
+func (cs ConnectionState) String() string {
+	switch cs {
+	case ConnectionStateConnected:
+		return "Connected"
+	case ConnectionStateSuspended:
+		return "Suspended"
+	case ConnectionStateReconnected:
+		return "Reconnected"
+	case ConnectionStateLost:
+		return "Lost"
+	case ConnectionStateExpired:
+		return "Expired"
+	}
+	return "Unknown"
+}
+
+// IsConnected returns true when the state means the session is usable
+func (cs ConnectionState) IsConnected() bool {
+	return cs == ConnectionStateConnected || cs == ConnectionStateReconnected
+}
+
+// ConnectionStateFunc callback function when client connection state changes
+type ConnectionStateFunc func(ConnectionState)
+
+// SetConnectionStateCallback registers callback function for connection state changes
+func SetConnectionStateCallback(stateCb ConnectionStateFunc) NodeOpionsFunc {
+	return func(c *Client) error {
+		c.AddConnectionStateListener(stateCb)
+		return nil
+	}
+}
+
+// SetSessionTimeout sets zookeeper session timeout
+func SetSessionTimeout(timeout time.Duration) NodeOpionsFunc {
+	return func(c *Client) error {
+		c.sessionTimeout = timeout
+		return nil
+	}
+}
+
+// ConnectionState returns current connection state
+func (c *Client) ConnectionState() ConnectionState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// AddConnectionStateListener registers fn to be called on every connection
+// state change. Listeners are called in order from a single goroutine and
+// must not block. The returned function removes the listener.
+func (c *Client) AddConnectionStateListener(fn ConnectionStateFunc) func() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.stateListenerSeq++
+	id := c.stateListenerSeq
+	if c.stateListeners == nil {
+		c.stateListeners = make(map[int]ConnectionStateFunc)
+	}
+	c.stateListeners[id] = fn
+
+	return func() {
+		c.stateMu.Lock()
+		delete(c.stateListeners, id)
+		c.stateMu.Unlock()
+	}
+}
+
+// subscribeConnectionState is used by recipes to receive state changes in
+// their own loop. States are queued, so a slow recipe never misses one.
+func (c *Client) subscribeConnectionState() (<-chan ConnectionState, func()) {
+	ch := make(chan ConnectionState, 16)
+	done := make(chan struct{})
+
+	remove := c.AddConnectionStateListener(func(state ConnectionState) {
+		select {
+		case ch <- state:
+		case <-done:
+		}
+	})
+
+	return ch, func() {
+		remove()
+		close(done)
+	}
+}
+
+// disconnecting returns true once Disconnect was called
+func (c *Client) disconnecting() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) isConnected() bool {
+	return c.ConnectionState().IsConnected()
+}
+
+func (c *Client) setConnectionState(state ConnectionState) {
+	c.stateMu.Lock()
+	if c.state == state {
+		c.stateMu.Unlock()
+		return
+	}
+	c.state = state
+	listeners := make([]ConnectionStateFunc, 0, len(c.stateListeners))
+	for id := 1; id <= c.stateListenerSeq; id++ {
+		if fn, ok := c.stateListeners[id]; ok {
+			listeners = append(listeners, fn)
+		}
+	}
+	c.stateMu.Unlock()
+
+	c.logger.Infof("Connection state changed to %s", state)
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// watchSession translates zookeeper session events into connection states.
+// It signals connected once the first session is established.
+func (c *Client) watchSession(events <-chan zk.Event, connected chan<- struct{}) {
+	var lostTimer <-chan time.Time
+	hasSession := false
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if event.Type != zk.EventSession {
+				continue
+			}
+
+			switch event.State {
+			case zk.StateHasSession:
+				lostTimer = nil
+				if !hasSession {
+					hasSession = true
+					c.setConnectionState(ConnectionStateConnected)
+					if connected != nil {
+						close(connected)
+						connected = nil
+					}
+				} else {
+					c.setConnectionState(ConnectionStateReconnected)
+				}
+			case zk.StateDisconnected:
+				if c.disconnecting() {
+					return
+				}
+				if hasSession && c.ConnectionState().IsConnected() {
+					c.setConnectionState(ConnectionStateSuspended)
+					lostTimer = time.After(c.sessionTimeout)
+				}
+			case zk.StateExpired:
+				lostTimer = nil
+				hasSession = false
+				c.setConnectionState(ConnectionStateExpired)
+			}
+		case <-lostTimer:
+			lostTimer = nil
+			if c.ConnectionState() == ConnectionStateSuspended {
+				c.setConnectionState(ConnectionStateLost)
+			}
+		}
+	}
+}
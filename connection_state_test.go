@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionStateLost(t *testing.T) {
+	assert := assert.New(t)
+	backend := testStore.NewBackend()
+	states := make(chan ConnectionState, 10)
+
+	client := NewClient(
+		SetBackend(backend),
+		SetSessionTimeout(50*time.Millisecond),
+		SetConnectionStateCallback(func(state ConnectionState) {
+			states <- state
+		}),
+	)
+	assert.Nil(client.Connect())
+	assert.Equal(<-states, ConnectionStateConnected)
+	assert.True(client.isConnected())
+
+	// suspended for longer than the session timeout
+	backend.Suspend()
+	assert.Equal(<-states, ConnectionStateSuspended)
+	assert.Equal(<-states, ConnectionStateLost)
+	assert.False(client.isConnected())
+
+	// the session expired meanwhile, a new one is created
+	time.Sleep(50 * time.Millisecond)
+	backend.Resume()
+	assert.Equal(<-states, ConnectionStateExpired)
+	assert.Equal(<-states, ConnectionStateConnected)
+	assert.Equal(client.ConnectionState(), ConnectionStateConnected)
+
+	client.Disconnect()
+}
+
+func TestConnectionStateListenerRemoved(t *testing.T) {
+	assert := assert.New(t)
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Nil(client.Connect())
+
+	states := make(chan ConnectionState, 10)
+	remove := client.AddConnectionStateListener(func(state ConnectionState) {
+		states <- state
+	})
+
+	backend.Suspend()
+	assert.Equal(<-states, ConnectionStateSuspended)
+
+	remove()
+	backend.Resume()
+	assert.Eventually(client.isConnected, time.Second, 10*time.Millisecond)
+	assert.Equal(len(states), 0)
+
+	client.Disconnect()
+}
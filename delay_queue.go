@@ -0,0 +1,52 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DelayQueue distributed queue whose items are taken once they are due,
+// earliest first. The due time is kept in the item name with millisecond
+// precision, so clients should have their clocks in sync.
+type DelayQueue struct {
+	queue *Queue
+}
+
+// Put adds data due at due
+func (dq *DelayQueue) Put(ctx context.Context, data []byte, due time.Time) error {
+	if !dq.queue.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// rounded up, so it's never taken before due
+	millis := (due.UnixNano() + int64(time.Millisecond) - 1) / int64(time.Millisecond)
+	if millis < 0 {
+		millis = 0
+	}
+	return dq.queue.put(dq.queue.keyedName(uint64(millis)), data)
+}
+
+// Take blocks until the earliest item is due and taken or ctx is done. It
+// waits for the earliest item with a timer and for new items with
+// watches, the queue isn't polled.
+func (dq *DelayQueue) Take(ctx context.Context) (*QueueItem, error) {
+	return dq.queue.Take(ctx)
+}
+
+// Peek returns the data of the earliest item due without taking it
+func (dq *DelayQueue) Peek() ([]byte, error) {
+	return dq.queue.Peek()
+}
+
+// NewDelayQueue returns new distributed delay queue
+func NewDelayQueue(c *Client, path string, options ...QueueOptionsFunc) *DelayQueue {
+	q := NewQueue(c, path, options...)
+	q.keyed = true
+	q.delayed = true
+	return &DelayQueue{queue: q}
+}
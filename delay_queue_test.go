@@ -0,0 +1,58 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayQueue(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/queue/delay"
+
+	producer := NewDelayQueue(clients[0], path)
+	consumer := NewDelayQueue(clients[1], path)
+
+	start := time.Now()
+	assert.Equal(producer.Put(context.Background(), []byte("later"), start.Add(300*time.Millisecond)), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("soon"), start.Add(150*time.Millisecond)), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("past"), start.Add(-time.Second)), nil)
+
+	item, err := consumer.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item.Data), "past")
+
+	// nothing due yet
+	_, err = consumer.Peek()
+	assert.Equal(err, ErrQueueEmpty)
+
+	item, err = consumer.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item.Data), "soon")
+	assert.True(time.Since(start) >= 150*time.Millisecond)
+
+	// an earlier item put while waiting is taken first
+	waiting := make(chan *QueueItem, 1)
+	go func() {
+		item, _ := consumer.Take(context.Background())
+		waiting <- item
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(producer.Put(context.Background(), []byte("now"), time.Now()), nil)
+	assert.Equal(string((<-waiting).Data), "now")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = consumer.Take(ctx)
+	assert.Equal(err, context.DeadlineExceeded)
+	cancel()
+
+	item, err = consumer.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item.Data), "later")
+	assert.True(time.Since(start) >= 300*time.Millisecond)
+
+	closeClients(clients)
+}
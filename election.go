@@ -1,10 +1,12 @@
 package supervisor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -31,11 +33,66 @@ func (ns NodeRole) String() string {
 // NodeRoleChangeFunc callback function when node changes role (Master or Slave)
 type NodeRoleChangeFunc func()
 
+const (
+	// RoleChangeElected node owns the lowest sequence node
+	RoleChangeElected RoleChangeReason = 1
+
+	// RoleChangeSuspended connection was suspended, leadership can't be
+	// guaranteed until it's reconnected
+	RoleChangeSuspended RoleChangeReason = 2
+
+	// RoleChangeLost connection was lost
+	RoleChangeLost RoleChangeReason = 3
+
+	// RoleChangeExpired session expired and the election node with it
+	RoleChangeExpired RoleChangeReason = 4
+
+	// RoleChangeStopped Stop was called
+	RoleChangeStopped RoleChangeReason = 5
+
+	// RoleChangeNodeDeleted election node was deleted by someone else
+	RoleChangeNodeDeleted RoleChangeReason = 6
+)
+
+// RoleChangeReason why node role changed
+type RoleChangeReason int32
+
+func (r RoleChangeReason) String() string {
+	switch r {
+	case RoleChangeElected:
+		return "Elected"
+	case RoleChangeSuspended:
+		return "Suspended"
+	case RoleChangeLost:
+		return "Lost"
+	case RoleChangeExpired:
+		return "Expired"
+	case RoleChangeStopped:
+		return "Stopped"
+	case RoleChangeNodeDeleted:
+		return "NodeDeleted"
+	}
+	return "Unknown"
+}
+
+// RoleChange holds a role transition
+type RoleChange struct {
+	From   NodeRole
+	To     NodeRole
+	Reason RoleChangeReason
+
+	// Token fencing token of the leadership, zero when revoked
+	Token FencingToken
+}
+
+// RoleChangeFunc callback function for role transitions
+type RoleChangeFunc func(RoleChange)
+
 // ByNodeGUID order list of nodes by incremental id
 type ByNodeGUID []string
 
 func (ni ByNodeGUID) getID(nodeGUID string) int64 {
-	id, _ := strconv.ParseInt(nodeGUID[strings.LastIndex(nodeGUID, "-")+1:], 10, 16)
+	id, _ := strconv.ParseInt(nodeGUID[strings.LastIndex(nodeGUID, "-")+1:], 10, 64)
 	return id
 }
 
@@ -54,75 +111,229 @@ func (ni ByNodeGUID) Less(i, j int) bool {
 // RoleSelector holds role selector information
 type RoleSelector struct {
 	client *Client
+	ops    *clientOps
+
+	path     string
+	guid     string
+	nodePath string
+	Role     NodeRole
+
+	tokenMu sync.Mutex
+	token   FencingToken
 
-	notificationSent bool
-	path             string
-	guid             string
-	nodePath         string
-	Role             NodeRole
+	onElected []RoleChangeFunc
+	onRevoked []RoleChangeFunc
 
+	// IsMaster receives true when node turns master, it's dropped while
+	// the previous one was not read
 	IsMaster chan bool
 	Error    chan error
 	close    chan bool
+	done     chan struct{}
 }
 
 // Start starts listening for node role change
 func (rs *RoleSelector) Start() {
-	if !rs.client.isConnected {
-		rs.Error <- errors.New("Client not connected")
+	if err := rs.StartContext(context.Background()); err != nil {
+		rs.Error <- err
 	}
+}
 
-	_, err := rs.client.createParentNodeIfNotExists(rs.path, []byte{})
-	if err != nil {
-		rs.Error <- err
+// StartContext starts listening for node role change until Stop is called
+// or ctx is done. When ctx is done the election node is removed.
+func (rs *RoleSelector) StartContext(ctx context.Context) error {
+	if !rs.client.isConnected() {
+		return errors.New("Client not connected")
 	}
 
-	abspath, guid, err := rs.client.createProtectedEphemeralSequential(rs.path, []byte{})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := rs.register(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if errLeave := rs.leave(); errLeave != nil {
+			rs.client.logger.Errorf("%s", errLeave.Error())
+		}
+		return err
+	}
+
+	go rs.listen(ctx)
+	return nil
+}
+
+// register creates the ephemeral node used by this selector to take
+// part in the election, together with the election node when it's missing
+func (rs *RoleSelector) register() error {
+	abspath, guid, err := rs.ops.createProtectedEphemeralSequential(rs.path, []byte{})
 	if err != nil {
-		rs.Error <- fmt.Errorf("%s - %s", err.Error(), rs.path)
+		return fmt.Errorf("%s - %s", err.Error(), rs.path)
 	}
 
 	rs.nodePath = abspath
 	rs.guid = guid
-
-	go rs.listen()
+	return nil
 }
 
-func (rs *RoleSelector) listen() {
+func (rs *RoleSelector) listen(ctx context.Context) {
+	states, unsubscribe := rs.client.subscribeConnectionState()
+	defer close(rs.done)
+	defer unsubscribe()
+
 	for {
-		children, _, channel, err := rs.client.childrenWatch(rs.path)
-		if err != nil {
-			rs.Error <- err
-		}
+		var channel <-chan zk.Event
 
-		if len(children) == 1 && !rs.notificationSent {
-			rs.client.currentRole = NodeRoleMaster
-			rs.notificationSent = true
-			rs.Role = NodeRoleMaster
-			rs.IsMaster <- true
+		if rs.nodePath != "" {
+			ch, err := rs.watchRole()
+			if err != nil {
+				rs.Error <- err
+			} else if ch == nil {
+				continue
+			}
+			channel = ch
 		}
 
 		select {
-		case event := <-channel:
-			rs.notify(event)
+		case <-channel:
+		case state := <-states:
+			rs.handleConnectionState(state)
 		case <-rs.close:
+			return
+		case <-ctx.Done():
+			if err := rs.leave(); err != nil {
+				rs.client.logger.Errorf("%s", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// OnElected registers callback function called every time node turns master
+func (rs *RoleSelector) OnElected(fn RoleChangeFunc) {
+	rs.onElected = append(rs.onElected, fn)
+}
+
+// OnRevoked registers callback function called every time node stops being
+// master, the reason tells why
+func (rs *RoleSelector) OnRevoked(fn RoleChangeFunc) {
+	rs.onRevoked = append(rs.onRevoked, fn)
+}
+
+// watchRole turns current node master when it owns the lowest sequence
+// and watches the only node that can change it: the one right ahead of
+// ours, or our own node when master. Nil channel means the nodes changed
+// meanwhile and must be read again.
+func (rs *RoleSelector) watchRole() (<-chan zk.Event, error) {
+	children, err := rs.ops.getSortedNodeGUIDList(rs.path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := indexOfGUID(children, rs.guid)
+	if idx < 0 {
+		// someone removed our node, take part in the election again
+		rs.setRole(NodeRoleSlave, RoleChangeNodeDeleted)
+		return nil, rs.register()
+	}
 
+	watched := rs.nodePath
+	if idx == 0 {
+		if rs.Role != NodeRoleMaster {
+			token, err := rs.ops.fencingToken(rs.nodePath)
+			if err != nil {
+				return nil, err
+			}
+			rs.setToken(token)
 		}
+		rs.setRole(NodeRoleMaster, RoleChangeElected)
+	} else {
+		watched = rs.path + "/" + children[idx-1]
+	}
+
+	exists, _, ch, err := rs.ops.existsWatch(watched)
+	if err != nil || !exists {
+		return nil, err
 	}
+	return ch, nil
+}
+
+// FencingToken returns the token of the current leadership, it's zero
+// while not master
+func (rs *RoleSelector) FencingToken() FencingToken {
+	rs.tokenMu.Lock()
+	defer rs.tokenMu.Unlock()
+	return rs.token
+}
+
+func (rs *RoleSelector) setToken(token FencingToken) {
+	rs.tokenMu.Lock()
+	rs.token = token
+	rs.tokenMu.Unlock()
 }
-func (rs *RoleSelector) notify(event zk.Event) {
-	if event.Type == zk.EventNodeChildrenChanged {
-		nodeGUIDList, err := rs.client.getSortedNodeGUIDList(rs.path)
-		if err != nil {
-			rs.Error <- err
+
+// setRole changes current role and notifies listeners when it differs
+func (rs *RoleSelector) setRole(role NodeRole, reason RoleChangeReason) {
+	if rs.Role == role {
+		return
+	}
+
+	if role != NodeRoleMaster {
+		rs.setToken(0)
+	}
+
+	change := RoleChange{From: rs.Role, To: role, Reason: reason, Token: rs.FencingToken()}
+	rs.Role = role
+	rs.client.currentRole = role
+
+	if role == NodeRoleMaster {
+		for _, fn := range rs.onElected {
+			fn(change)
+		}
+
+		// never blocks, callers using only callbacks don't read it
+		select {
+		case rs.IsMaster <- true:
+		default:
 		}
+		return
+	}
 
-		// notify only if current node turns master
-		if len(nodeGUIDList) > 0 {
-			if nodeGUIDList[0] == rs.guid && rs.client.currentRole == NodeRoleSlave && rs.notificationSent == false {
-				rs.notificationSent = true
-				rs.Role = NodeRoleMaster
-				rs.IsMaster <- true
+	for _, fn := range rs.onRevoked {
+		fn(change)
+	}
+}
+
+func (rs *RoleSelector) handleConnectionState(state ConnectionState) {
+	switch state {
+	case ConnectionStateSuspended, ConnectionStateLost:
+		if rs.client.isConnected() {
+			// already reconnected, role is checked again by listen
+			return
+		}
+
+		// can't be sure we still own the lowest node
+		reason := RoleChangeSuspended
+		if state == ConnectionStateLost {
+			reason = RoleChangeLost
+		}
+		rs.setRole(NodeRoleSlave, reason)
+	case ConnectionStateExpired:
+		if rs.ops.ownsNode(rs.nodePath) {
+			// already registered again with the new session
+			return
+		}
+
+		// ephemeral node went away with the session
+		rs.setRole(NodeRoleSlave, RoleChangeExpired)
+		rs.nodePath = ""
+		rs.guid = ""
+	case ConnectionStateConnected:
+		if rs.nodePath == "" {
+			if err := rs.register(); err != nil {
+				rs.Error <- err
 			}
 		}
 	}
@@ -130,19 +341,34 @@ func (rs *RoleSelector) notify(event zk.Event) {
 
 // Stop stops listening for node role change
 func (rs *RoleSelector) Stop() error {
-	rs.close <- true
+	select {
+	case rs.close <- true:
+	case <-rs.done:
+	}
+
+	return rs.leave()
+}
+
+// leave steps down and removes the election node, the election path is
+// removed too when no other node is left
+func (rs *RoleSelector) leave() error {
+	rs.setRole(NodeRoleSlave, RoleChangeStopped)
 
-	if err := rs.client.deleteNodeLastVersion(rs.nodePath); err != nil {
-		return fmt.Errorf("Could not remove node %s - %s", rs.nodePath, err.Error())
+	if rs.nodePath != "" {
+		if err := rs.ops.deleteNodeLastVersion(rs.nodePath); err != nil {
+			return fmt.Errorf("Could not remove node %s - %s", rs.nodePath, err.Error())
+		}
+		rs.nodePath = ""
+		rs.guid = ""
 	}
 
-	nodeGUIDList, err := rs.client.getSortedNodeGUIDList(rs.path)
+	nodeGUIDList, err := rs.ops.getSortedNodeGUIDList(rs.path)
 	if err != nil {
 		return err
 	}
 
 	if len(nodeGUIDList) == 0 {
-		if err := rs.client.deleteNodeLastVersion(rs.path); err != nil {
+		if err := rs.ops.deleteNodeLastVersion(rs.path); err != nil && err != zk.ErrNotEmpty {
 			return err
 		}
 	}
@@ -150,15 +376,42 @@ func (rs *RoleSelector) Stop() error {
 	return nil
 }
 
+// indexOfGUID returns guid position in list or -1
+func indexOfGUID(list []string, guid string) int {
+	for idx, item := range list {
+		if item == guid {
+			return idx
+		}
+	}
+	return -1
+}
+
+// RoleSelectorOptionsFunc role selector definition
+type RoleSelectorOptionsFunc func(*RoleSelector)
+
+// SetRoleSelectorRetryPolicy overrides client retry policy for this role selector
+func SetRoleSelectorRetryPolicy(policy RetryPolicy) RoleSelectorOptionsFunc {
+	return func(rs *RoleSelector) {
+		rs.ops.policy = policy
+	}
+}
+
 // NewRoleSelector returns new role selector for master election
-func NewRoleSelector(c *Client, path string) *RoleSelector {
+func NewRoleSelector(c *Client, path string, options ...RoleSelectorOptionsFunc) *RoleSelector {
 	rs := RoleSelector{
 		client:   c,
+		ops:      c.ops(nil),
 		path:     path,
 		Role:     NodeRoleSlave,
-		IsMaster: make(chan bool),
+		IsMaster: make(chan bool, 1),
 		Error:    make(chan error),
 		close:    make(chan bool),
+		done:     make(chan struct{}),
 	}
+
+	for _, option := range options {
+		option(&rs)
+	}
+
 	return &rs
 }
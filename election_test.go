@@ -1,19 +1,28 @@
 package supervisor
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testStore in-process store shared by test clients
+var testStore = NewMemoryStore()
+
+func newTestClient() *Client {
+	c := NewClient(
+		SetBackend(testStore.NewBackend()),
+	)
+	c.Connect()
+	return c
+}
+
 func makeClientSlice(q int) []*Client {
 	var r []*Client
 	for i := 0; i < q; i++ {
-		c := NewClient(
-			SetZookeeperNodes("127.0.0.1"),
-		)
-		c.Connect()
-		r = append(r, c)
+		r = append(r, newTestClient())
 	}
 	return r
 }
@@ -24,6 +33,21 @@ func closeClients(clients []*Client) {
 	}
 }
 
+// removeTestTree removes path and every node under it, so tests can run
+// again on the shared store
+func removeTestTree(c *Client, path string) error {
+	children, _, err := c.backend.Children(path)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := removeTestTree(c, path+"/"+child); err != nil {
+			return err
+		}
+	}
+	return c.backend.Delete(path, -1)
+}
+
 func createElection(clients []*Client) []*RoleSelector {
 	lc := len(clients)
 	r := make([]*RoleSelector, lc)
@@ -77,3 +101,129 @@ func TestElectionDisconnect(t *testing.T) {
 
 	closeClients(clients)
 }
+
+func nextRoleChange(t *testing.T, changes <-chan RoleChange) RoleChange {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for role change")
+	}
+	return RoleChange{}
+}
+
+func TestElectionCallbacksOnly(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/election/callbacks"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+	other := newTestClient()
+
+	// IsMaster is never read
+	changes := make(chan RoleChange, 10)
+	election := NewRoleSelector(client, path)
+	election.OnElected(func(change RoleChange) {
+		changes <- change
+	})
+	election.OnRevoked(func(change RoleChange) {
+		changes <- change
+	})
+	election.Start()
+
+	change := nextRoleChange(t, changes)
+	assert.Equal(change.From, NodeRoleSlave)
+	assert.Equal(change.To, NodeRoleMaster)
+	assert.Equal(change.Reason, RoleChangeElected)
+	assert.True(change.Token.IsValid())
+
+	// leadership can't be guaranteed while suspended
+	backend.Suspend()
+	change = nextRoleChange(t, changes)
+	assert.Equal(change, RoleChange{From: NodeRoleMaster, To: NodeRoleSlave, Reason: RoleChangeSuspended})
+	backend.Resume()
+	assert.Equal(nextRoleChange(t, changes).Reason, RoleChangeElected)
+
+	// node removed by someone else
+	children, err := other.ops(nil).getSortedNodeGUIDList(path)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 1)
+	assert.Equal(other.backend.Delete(path+"/"+children[0], -1), nil)
+	change = nextRoleChange(t, changes)
+	assert.Equal(change, RoleChange{From: NodeRoleMaster, To: NodeRoleSlave, Reason: RoleChangeNodeDeleted})
+	assert.Equal(nextRoleChange(t, changes).Reason, RoleChangeElected)
+
+	// elected again with the new session
+	backend.Expire()
+	assert.Equal(nextRoleChange(t, changes).To, NodeRoleSlave)
+	assert.Equal(nextRoleChange(t, changes).Reason, RoleChangeElected)
+
+	assert.Equal(election.Stop(), nil)
+	change = nextRoleChange(t, changes)
+	assert.Equal(change, RoleChange{From: NodeRoleMaster, To: NodeRoleSlave, Reason: RoleChangeStopped})
+
+	client.Disconnect()
+	other.Disconnect()
+}
+
+func TestElectionRevokedOnExpire(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/election/expired"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+
+	changes := make(chan RoleChange, 10)
+	election := NewRoleSelector(client, path)
+	election.OnRevoked(func(change RoleChange) {
+		changes <- change
+	})
+	election.Start()
+	<-election.IsMaster
+
+	// session expired while suspended, the node is gone on resume
+	backend.Suspend()
+	assert.Equal(nextRoleChange(t, changes).Reason, RoleChangeSuspended)
+	backend.Expire()
+	backend.Resume()
+	<-election.IsMaster
+
+	assert.Equal(election.Stop(), nil)
+	assert.Equal(nextRoleChange(t, changes).Reason, RoleChangeStopped)
+	client.Disconnect()
+}
+
+func TestElectionStartContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/election/canceled"
+
+	master := NewRoleSelector(clients[0], path)
+	master.Start()
+	<-master.IsMaster
+
+	slave := NewRoleSelector(clients[1], path)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(slave.StartContext(ctx), context.Canceled)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	assert.Equal(slave.StartContext(ctx), nil)
+
+	children, _, err := clients[0].backend.Children(path)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 2)
+
+	// the slave node is removed when ctx is done
+	cancel()
+	assert.Eventually(func() bool {
+		children, _, _ := clients[0].backend.Children(path)
+		return len(children) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(slave.Role, NodeRoleSlave)
+
+	assert.Equal(master.Stop(), nil)
+	closeClients(clients)
+}
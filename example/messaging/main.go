@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/mausimag/supervisor"
+)
+
+var (
+	zookeeperServers = flag.String("s", "127.0.0.1", "Zookeeper servers separated by ','")
+)
+
+func main() {
+	client := supervisor.NewClient(
+		supervisor.SetZookeeperNodes(*zookeeperServers),
+		supervisor.SetNodeReceiveMessageCallback(func(payload []byte) {
+			fmt.Println("Received:", string(payload))
+		}),
+	)
+
+	if err := client.Connect(); err != nil {
+		fmt.Println(err.Error())
+	}
+
+	election := supervisor.NewRoleSelector(client, "/supervisor/example/messaging")
+	election.Start()
+
+	for {
+		select {
+		case <-election.IsMaster:
+			fmt.Println("CURRENT NODE IS MASTER")
+		case err := <-election.Error:
+			fmt.Println("Error:", err)
+		case <-time.After(5 * time.Second):
+			if election.Role == supervisor.NodeRoleMaster {
+				fmt.Println(client.Broadcast([]byte("ping from " + client.GUID())))
+			}
+		}
+	}
+}
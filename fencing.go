@@ -0,0 +1,151 @@
+package supervisor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ErrStaleFencingToken returned when a token older than the latest one
+// seen is used, the holder lost the lock or leadership meanwhile
+var ErrStaleFencingToken = errors.New("Stale fencing token")
+
+// FencingToken monotonically increasing token handed out on every
+// successful lock acquire and election win. It's the zxid that created
+// the lock or election node, so a newer holder always gets a greater one.
+type FencingToken int64
+
+// IsValid returns false for the zero token, used while nothing is held
+func (t FencingToken) IsValid() bool {
+	return t > 0
+}
+
+// FencingValidator keeps the latest token seen for each resource in
+// memory. Downstream services use it to reject writes from old holders.
+type FencingValidator struct {
+	mu     sync.Mutex
+	latest map[string]FencingToken
+}
+
+// Validate accepts token when it's not older than the latest token seen
+// for resource, and records it as the latest one
+func (v *FencingValidator) Validate(resource string, token FencingToken) error {
+	if !token.IsValid() {
+		return fmt.Errorf("Invalid fencing token %d", token)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if token < v.latest[resource] {
+		return ErrStaleFencingToken
+	}
+	v.latest[resource] = token
+	return nil
+}
+
+// Latest returns the latest token seen for resource
+func (v *FencingValidator) Latest(resource string) FencingToken {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.latest[resource]
+}
+
+// NewFencingValidator returns new in memory fencing validator
+func NewFencingValidator() *FencingValidator {
+	return &FencingValidator{latest: make(map[string]FencingToken)}
+}
+
+// FencingGuard keeps the latest token in a zookeeper node, so every
+// process writing to the same resource agrees on it
+type FencingGuard struct {
+	ops  *clientOps
+	path string
+}
+
+// Check accepts token when it's not older than the latest token stored,
+// and stores it as the latest one
+func (g *FencingGuard) Check(token FencingToken) error {
+	if !token.IsValid() {
+		return fmt.Errorf("Invalid fencing token %d", token)
+	}
+
+	for {
+		data, stat, err := g.ops.get(g.path)
+		if err == zk.ErrNoNode {
+			if err := g.ops.createNode(g.path, g.toBytes(token)); err != nil {
+				if err == zk.ErrNodeExists {
+					// another holder stored its token meanwhile
+					continue
+				}
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), g.path)
+		}
+
+		latest := g.fromBytes(data)
+		if token < latest {
+			return ErrStaleFencingToken
+		}
+		if token == latest {
+			return nil
+		}
+
+		if _, err := g.ops.setNodeData(g.path, g.toBytes(token), stat.Version); err != nil {
+			if err == zk.ErrBadVersion {
+				// another holder stored its token meanwhile
+				continue
+			}
+			return fmt.Errorf("%s - %s", err.Error(), g.path)
+		}
+		return nil
+	}
+}
+
+// Latest returns the latest token stored, zero when none was
+func (g *FencingGuard) Latest() (FencingToken, error) {
+	data, _, err := g.ops.get(g.path)
+	if err == zk.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return g.fromBytes(data), nil
+}
+
+func (g *FencingGuard) toBytes(token FencingToken) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(token))
+	return buf
+}
+
+func (g *FencingGuard) fromBytes(data []byte) FencingToken {
+	if len(data) < 8 {
+		return 0
+	}
+	return FencingToken(binary.LittleEndian.Uint64(data))
+}
+
+// NewFencingGuard returns new fencing guard storing tokens at path
+func NewFencingGuard(c *Client, path string) *FencingGuard {
+	return &FencingGuard{ops: c.ops(nil), path: path}
+}
+
+// fencingToken returns the token of a lock or election node
+func (o *clientOps) fencingToken(path string) (FencingToken, error) {
+	_, stat, err := o.checkAndGetNode(path)
+	if err != nil {
+		return 0, err
+	}
+	if stat == nil {
+		return 0, zk.ErrNoNode
+	}
+	return FencingToken(stat.Czxid), nil
+}
@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFencingTokenMutex(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/fencing/mutex"
+
+	lock01 := NewMutex(clients[0], lockPath)
+	lock02 := NewMutex(clients[1], lockPath)
+	assert.Equal(lock01.FencingToken(), FencingToken(0))
+
+	assert.Equal(lock01.Acquire(1, time.Second), nil)
+	token01 := lock01.FencingToken()
+	assert.True(token01.IsValid())
+
+	// reentrant acquire keeps the token
+	assert.Equal(lock01.Acquire(1, time.Second), nil)
+	assert.Equal(lock01.FencingToken(), token01)
+	assert.Equal(lock01.Release(), nil)
+	assert.Equal(lock01.Release(), nil)
+	assert.Equal(lock01.FencingToken(), FencingToken(0))
+
+	assert.Equal(lock02.Acquire(1, time.Second), nil)
+	token02 := lock02.FencingToken()
+	assert.True(token02 > token01)
+	assert.Equal(lock02.Release(), nil)
+
+	closeClients(clients)
+}
+
+func TestFencingTokenElection(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	election := createElection(clients)
+
+	token01 := election[0].FencingToken()
+	assert.True(token01.IsValid())
+	assert.Equal(election[1].FencingToken(), FencingToken(0))
+
+	changes := make(chan RoleChange, 1)
+	election[1].OnElected(func(change RoleChange) {
+		changes <- change
+	})
+
+	election[0].Stop()
+	<-election[1].IsMaster
+
+	change := <-changes
+	assert.True(change.Token > token01)
+	assert.Equal(election[1].FencingToken(), change.Token)
+
+	election[1].Stop()
+	closeClients(clients)
+}
+
+func TestFencingValidator(t *testing.T) {
+	assert := assert.New(t)
+	validator := NewFencingValidator()
+
+	assert.Equal(validator.Validate("db", 10), nil)
+	assert.Equal(validator.Validate("db", 10), nil)
+	assert.Equal(validator.Validate("db", 12), nil)
+	assert.Equal(validator.Validate("db", 11), ErrStaleFencingToken)
+	assert.Equal(validator.Validate("cache", 11), nil)
+	assert.NotEqual(validator.Validate("db", 0), nil)
+	assert.Equal(validator.Latest("db"), FencingToken(12))
+}
+
+func TestFencingGuard(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	guardPath := "/supervisor/test/fencing/guard"
+
+	guard01 := NewFencingGuard(clients[0], guardPath)
+	guard02 := NewFencingGuard(clients[1], guardPath)
+
+	latest, err := guard01.Latest()
+	assert.Equal(err, nil)
+	assert.Equal(latest, FencingToken(0))
+
+	assert.Equal(guard01.Check(10), nil)
+	assert.Equal(guard02.Check(12), nil)
+	assert.Equal(guard01.Check(10), ErrStaleFencingToken)
+	assert.Equal(guard02.Check(12), nil)
+
+	latest, err = guard01.Latest()
+	assert.Equal(err, nil)
+	assert.Equal(latest, FencingToken(12))
+
+	assert.Equal(clients[0].ops(nil).deleteNodeLastVersion(guardPath), nil)
+	closeClients(clients)
+}
+
+func TestFencingGuardCreatedMeanwhile(t *testing.T) {
+	assert := assert.New(t)
+	guardPath := "/supervisor/test/fencing/guard02"
+
+	client := newTestClient()
+	assert.Equal(NewFencingGuard(client, guardPath).Check(12), nil)
+
+	// the guard node is read as missing, the create fails and the token
+	// is compared with the one stored meanwhile
+	stale := NewClient(SetBackend(&staleBackend{MemoryBackend: testStore.NewBackend(), stale: guardPath}))
+	assert.Equal(stale.Connect(), nil)
+	assert.Equal(NewFencingGuard(stale, guardPath).Check(10), ErrStaleFencingToken)
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion(guardPath), nil)
+	client.Disconnect()
+	stale.Disconnect()
+}
@@ -0,0 +1,267 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// MemberJoined member joined the group
+	MemberJoined MemberEventType = iota
+	// MemberLeft member left the group or its session is gone
+	MemberLeft
+	// MemberUpdated member data changed
+	MemberUpdated
+)
+
+// MemberEventType type of group membership change
+type MemberEventType int
+
+func (t MemberEventType) String() string {
+	switch t {
+	case MemberJoined:
+		return "Joined"
+	case MemberLeft:
+		return "Left"
+	case MemberUpdated:
+		return "Updated"
+	}
+	return "Unknown"
+}
+
+// MemberEvent group membership change, Data is empty when a member left
+type MemberEvent struct {
+	Type MemberEventType
+	GUID string
+	Data []byte
+}
+
+// GroupMember member of a group of processes. Each member is an ephemeral
+// node holding the data it advertises, so members whose session is gone
+// leave the group.
+type GroupMember struct {
+	client *Client
+	ops    *clientOps
+	path   string
+
+	mu          sync.Mutex
+	guid        string
+	nodePath    string
+	data        []byte
+	left        chan struct{}
+	unsubscribe func()
+}
+
+// Join adds this member to the group advertising data. When the session
+// expires the member joins again with a new GUID. Retries stop when ctx is
+// done, the member doesn't join then.
+func (g *GroupMember) Join(ctx context.Context, data []byte) error {
+	if !g.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.nodePath != "" {
+		return errors.New("Group [" + g.path + "] already joined")
+	}
+
+	g.data = data
+	if err := g.register(g.ops.withContext(ctx)); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		// done while the node was created
+		if errDelete := g.ops.deleteNodeLastVersion(g.nodePath); errDelete != nil {
+			g.client.logger.Errorf("Could not remove node %s - %s", g.nodePath, errDelete.Error())
+		}
+		g.guid = ""
+		g.nodePath = ""
+		return err
+	}
+
+	states, unsubscribe := g.client.subscribeConnectionState()
+	g.unsubscribe = unsubscribe
+	g.left = make(chan struct{})
+	go g.watchConnection(states, g.left)
+
+	return nil
+}
+
+// Update replaces the data advertised by this member in place
+func (g *GroupMember) Update(data []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.nodePath == "" {
+		return errors.New("Group [" + g.path + "] not joined")
+	}
+
+	if _, err := g.ops.setNodeData(g.nodePath, data, -1); err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), g.nodePath)
+	}
+	g.data = data
+	return nil
+}
+
+// Leave removes this member from the group
+func (g *GroupMember) Leave() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.nodePath == "" {
+		return errors.New("Group [" + g.path + "] not joined")
+	}
+
+	// still a member until the node is gone, Leave can be tried again
+	if err := g.ops.deleteNodeLastVersion(g.nodePath); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", g.nodePath, err.Error())
+	}
+
+	close(g.left)
+	g.unsubscribe()
+	g.unsubscribe = nil
+	g.guid = ""
+	g.nodePath = ""
+	return nil
+}
+
+// GUID returns the GUID of this member in the group, empty while it's not
+// a member
+func (g *GroupMember) GUID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.guid
+}
+
+// Members returns the data advertised by every member by GUID
+func (g *GroupMember) Members() (map[string][]byte, error) {
+	children, err := g.ops.getSortedNodeGUIDList(g.path)
+	if err == zk.ErrNoNode {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", err.Error(), g.path)
+	}
+
+	members := make(map[string][]byte, len(children))
+	for _, guid := range children {
+		data, _, err := g.ops.get(g.path + "/" + guid)
+		if err == zk.ErrNoNode {
+			// left meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", err.Error(), g.path)
+		}
+		members[guid] = data
+	}
+	return members, nil
+}
+
+// Watch sends membership changes until ctx is done, the channel is closed
+// then. Members already in the group are sent as joined first. Each member
+// data is watched once and the watch is armed again when it fires, so
+// changes between two events are sent as one update.
+func (g *GroupMember) Watch(ctx context.Context) <-chan MemberEvent {
+	events := make(chan MemberEvent)
+
+	go func() {
+		defer close(events)
+
+		g.ops.watchChildren(ctx, g.path, func(change childChange) bool {
+			event := MemberEvent{Type: MemberUpdated, GUID: change.name, Data: change.data}
+			switch {
+			case change.removed:
+				event = MemberEvent{Type: MemberLeft, GUID: change.name}
+			case change.added:
+				event.Type = MemberJoined
+			}
+
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, nil)
+	}()
+
+	return events
+}
+
+// register creates the member node using ops, together with the group
+// node when it's missing
+func (g *GroupMember) register(ops *clientOps) error {
+	abspath, guid, err := ops.createProtectedEphemeralSequential(g.path, g.data)
+	if err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), g.path)
+	}
+
+	g.nodePath = abspath
+	g.guid = guid
+	return nil
+}
+
+// watchConnection joins the group again when the member node went away
+// with an expired session
+func (g *GroupMember) watchConnection(states <-chan ConnectionState, left chan struct{}) {
+	for {
+		select {
+		case state := <-states:
+			if state != ConnectionStateConnected {
+				continue
+			}
+
+			g.mu.Lock()
+			if g.nodePath != "" && !g.ops.ownsNode(g.nodePath) {
+				if err := g.register(g.ops); err != nil {
+					g.client.logger.Errorf("Could not join group %s - %s", g.path, err.Error())
+				}
+			}
+			g.mu.Unlock()
+		case <-left:
+			return
+		case <-g.ops.done:
+			return
+		}
+	}
+}
+
+// GroupMemberOptionsFunc group member definition
+type GroupMemberOptionsFunc func(*GroupMember)
+
+// SetGroupMemberRetryPolicy overrides client retry policy for this member
+func SetGroupMemberRetryPolicy(policy RetryPolicy) GroupMemberOptionsFunc {
+	return func(g *GroupMember) {
+		g.ops.policy = policy
+	}
+}
+
+// NewGroupMember returns new member of the group at path
+func NewGroupMember(c *Client, path string, options ...GroupMemberOptionsFunc) *GroupMember {
+	g := GroupMember{
+		client: c,
+		ops:    c.ops(nil),
+		path:   path,
+	}
+
+	for _, option := range options {
+		option(&g)
+	}
+
+	return &g
+}
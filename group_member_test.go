@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupMember(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(3)
+	path := "/supervisor/test/group/group01"
+
+	member01 := NewGroupMember(clients[0], path)
+	member02 := NewGroupMember(clients[1], path)
+	observer := NewGroupMember(clients[2], path)
+
+	members, err := observer.Members()
+	assert.Equal(err, nil)
+	assert.Equal(len(members), 0)
+
+	assert.Equal(member01.Join(context.Background(), []byte("10.0.0.1:80")), nil)
+	assert.NotEqual(member01.Join(context.Background(), []byte{}), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := observer.Watch(ctx)
+
+	// members already in the group come first
+	event := <-events
+	assert.Equal(event, MemberEvent{Type: MemberJoined, GUID: member01.GUID(), Data: []byte("10.0.0.1:80")})
+
+	assert.Equal(member02.Join(context.Background(), []byte("10.0.0.2:80")), nil)
+	event = <-events
+	assert.Equal(event, MemberEvent{Type: MemberJoined, GUID: member02.GUID(), Data: []byte("10.0.0.2:80")})
+
+	members, err = observer.Members()
+	assert.Equal(err, nil)
+	assert.Equal(members, map[string][]byte{
+		member01.GUID(): []byte("10.0.0.1:80"),
+		member02.GUID(): []byte("10.0.0.2:80"),
+	})
+
+	assert.Equal(member01.Update([]byte("10.0.0.1:81")), nil)
+	event = <-events
+	assert.Equal(event, MemberEvent{Type: MemberUpdated, GUID: member01.GUID(), Data: []byte("10.0.0.1:81")})
+
+	guid := member02.GUID()
+	assert.Equal(member02.Leave(), nil)
+	assert.Equal(member02.GUID(), "")
+	event = <-events
+	assert.Equal(event, MemberEvent{Type: MemberLeft, GUID: guid})
+
+	cancel()
+	for range events {
+	}
+
+	assert.Equal(member01.Leave(), nil)
+	closeClients(clients)
+}
+
+func TestGroupMemberExpired(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/group/group02"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+	observerClient := newTestClient()
+
+	member := NewGroupMember(client, path)
+	observer := NewGroupMember(observerClient, path)
+	assert.Equal(member.Join(context.Background(), []byte("v1")), nil)
+	guid := member.GUID()
+
+	backend.Expire()
+
+	// member joins again with a new session
+	assert.Eventually(func() bool {
+		members, _ := observer.Members()
+		return len(members) == 1 && member.GUID() != guid
+	}, time.Second, 10*time.Millisecond)
+
+	members, err := observer.Members()
+	assert.Equal(err, nil)
+	assert.Equal(members[member.GUID()], []byte("v1"))
+
+	assert.Equal(member.Leave(), nil)
+	client.Disconnect()
+	observerClient.Disconnect()
+}
+
+func TestGroupMemberJoinContext(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/group/group03"
+
+	backend := testStore.NewBackend()
+	client := NewClient(
+		SetBackend(backend),
+		SetRetryPolicy(NewForeverRetry(10*time.Millisecond)),
+	)
+	assert.Equal(client.Connect(), nil)
+
+	// retries stop when ctx is done
+	member := NewGroupMember(client, path)
+	backend.Suspend()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	assert.Equal(member.Join(ctx, []byte("v1")), context.DeadlineExceeded)
+	cancel()
+	backend.Resume()
+
+	assert.Equal(member.GUID(), "")
+	members, err := member.Members()
+	assert.Equal(err, nil)
+	assert.Equal(len(members), 0)
+
+	client.Disconnect()
+}
+
+func TestGroupMemberLeaveAgain(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/group/group04"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+
+	member := NewGroupMember(client, path, SetGroupMemberRetryPolicy(NewFixedRetry(0, 0)))
+	assert.Equal(member.Join(context.Background(), []byte("v1")), nil)
+
+	// still a member when the node can't be removed
+	backend.Suspend()
+	assert.NotEqual(member.Leave(), nil)
+	backend.Resume()
+	assert.NotEqual(member.GUID(), "")
+
+	assert.Equal(member.Leave(), nil)
+	assert.Equal(member.GUID(), "")
+	members, err := member.Members()
+	assert.Equal(err, nil)
+	assert.Equal(len(members), 0)
+
+	assert.Equal(client.ops(nil).deleteBaseNode(path), nil)
+	client.Disconnect()
+}
@@ -0,0 +1,781 @@
+package supervisor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// MemoryStore in-process node tree shared by memory backends, it plays the
+// role of the zookeeper ensemble. Each backend created from the store owns
+// its own session, ephemeral nodes and watches.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	root          *memoryNode
+	zxid          int64
+	lastSessionID int64
+	sessions      map[int64]*MemoryBackend
+
+	dataWatches  map[string][]*memoryWatch
+	childWatches map[string][]*memoryWatch
+
+	// deferred holds watches fired inside a multi, they only fire when
+	// every operation succeeded
+	deferred []deferredFire
+	// undo holds what restores the nodes changed inside a multi, in the
+	// order they were changed
+	undo []func()
+
+	stats MemoryStats
+}
+
+type deferredFire struct {
+	watches   map[string][]*memoryWatch
+	path      string
+	eventType zk.EventType
+}
+
+// MemoryStats counts watches set and watch events delivered by a store
+type MemoryStats struct {
+	Watches int64
+	Events  int64
+}
+
+type memoryNode struct {
+	data     []byte
+	stat     zk.Stat
+	children map[string]*memoryNode
+}
+
+type memoryWatch struct {
+	backend *MemoryBackend
+	ch      chan zk.Event
+}
+
+// MemoryBackend backend session on a MemoryStore
+type MemoryBackend struct {
+	store *MemoryStore
+
+	sessionID      int64
+	sessionTimeout time.Duration
+	events         chan zk.Event
+
+	connected   bool
+	suspended   bool
+	closed      bool
+	pending     []pendingWatchEvent
+	expireTimer *time.Timer
+}
+
+type pendingWatchEvent struct {
+	ch    chan zk.Event
+	event zk.Event
+}
+
+// NewBackend returns new backend, every backend is a different session
+func (s *MemoryStore) NewBackend() *MemoryBackend {
+	return &MemoryBackend{store: s}
+}
+
+// Stats returns watches and events counted so far
+func (s *MemoryStore) Stats() MemoryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// ExpireSession expires the session as zookeeper would, removing its
+// ephemeral nodes and watches
+func (s *MemoryStore) ExpireSession(sessionID int64) {
+	s.mu.Lock()
+	b, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+
+	if ok {
+		b.Expire()
+	}
+}
+
+// Connect opens a new session, also after Close
+func (b *MemoryBackend) Connect(sessionTimeout time.Duration) (<-chan zk.Event, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	b.closed = false
+	b.sessionTimeout = sessionTimeout
+	b.events = make(chan zk.Event, 32)
+	b.connected = true
+	b.emit(zk.StateConnecting)
+	b.emit(zk.StateConnected)
+	b.store.newSession(b)
+
+	return b.events, nil
+}
+
+// Close closes the session, its ephemeral nodes are removed
+func (b *MemoryBackend) Close() {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if b.closed || !b.connected {
+		return
+	}
+
+	b.closed = true
+	if b.expireTimer != nil {
+		b.expireTimer.Stop()
+	}
+
+	b.suspended = false
+	b.flushPending()
+	b.store.closeSession(b, zk.ErrClosing)
+	b.emit(zk.StateDisconnected)
+	close(b.events)
+}
+
+// SessionID returns current session id
+func (b *MemoryBackend) SessionID() int64 {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	return b.sessionID
+}
+
+// Suspend simulates a connection loss. The session survives if Resume is
+// called before the session timeout, otherwise it expires.
+func (b *MemoryBackend) Suspend() {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if !b.connected || b.closed || b.suspended {
+		return
+	}
+
+	b.suspended = true
+	b.emit(zk.StateDisconnected)
+
+	sessionID := b.sessionID
+	b.expireTimer = time.AfterFunc(b.sessionTimeout, func() {
+		b.store.mu.Lock()
+		defer b.store.mu.Unlock()
+
+		if b.suspended && b.sessionID == sessionID {
+			b.store.closeSession(b, zk.ErrSessionExpired)
+		}
+	})
+}
+
+// Resume re-establishes the connection after Suspend. If the session
+// expired meanwhile a new one is created.
+func (b *MemoryBackend) Resume() {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if !b.suspended || b.closed {
+		return
+	}
+
+	b.expireTimer.Stop()
+	b.suspended = false
+	b.emit(zk.StateConnecting)
+	b.emit(zk.StateConnected)
+
+	if _, alive := b.store.sessions[b.sessionID]; alive {
+		b.emit(zk.StateHasSession)
+		b.flushPending()
+		return
+	}
+
+	b.emit(zk.StateExpired)
+	b.flushPending()
+	b.store.newSession(b)
+}
+
+// Expire expires current session, like zookeeper the client is
+// disconnected, notified about the expiration and a new session is created
+func (b *MemoryBackend) Expire() {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if !b.connected || b.closed {
+		return
+	}
+
+	if b.suspended {
+		// client will find out on Resume
+		b.store.closeSession(b, zk.ErrSessionExpired)
+		return
+	}
+
+	b.emit(zk.StateDisconnected)
+	b.store.closeSession(b, zk.ErrSessionExpired)
+	b.emit(zk.StateExpired)
+	b.store.newSession(b)
+}
+
+// Create creates node, flags accepts zk.FlagEphemeral and zk.FlagSequence
+func (b *MemoryBackend) Create(path string, data []byte, flags int32) (string, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return "", err
+	}
+	return b.store.create(b, path, data, flags)
+}
+
+// CreateProtectedEphemeralSequential creates ephemeral sequential node
+// with the same protected prefix used by zookeeper client
+func (b *MemoryBackend) CreateProtectedEphemeralSequential(path string, data []byte) (string, error) {
+	protected, err := protectedPath(path)
+	if err != nil {
+		return "", err
+	}
+	return b.Create(protected, data, zk.FlagEphemeral|zk.FlagSequence)
+}
+
+// Exists checks if node exists
+func (b *MemoryBackend) Exists(path string) (bool, *zk.Stat, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return false, nil, err
+	}
+	return b.store.exists(path)
+}
+
+// ExistsW checks if node exists and watches its creation, changes and deletion
+func (b *MemoryBackend) ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return false, nil, nil, err
+	}
+
+	exists, stat, err := b.store.exists(path)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return exists, stat, b.store.addWatch(b.store.dataWatches, b, path), nil
+}
+
+// Get returns node data
+func (b *MemoryBackend) Get(path string) ([]byte, *zk.Stat, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	return b.store.get(path)
+}
+
+// GetW returns node data and watches its changes and deletion
+func (b *MemoryBackend) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	data, stat, err := b.store.get(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data, stat, b.store.addWatch(b.store.dataWatches, b, path), nil
+}
+
+// Set sets node data if version matches, -1 matches any version
+func (b *MemoryBackend) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.store.set(path, data, version)
+}
+
+// Delete deletes node if version matches, -1 matches any version
+func (b *MemoryBackend) Delete(path string, version int32) error {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return err
+	}
+	return b.store.delete(path, version)
+}
+
+// Children returns node children names
+func (b *MemoryBackend) Children(path string) ([]string, *zk.Stat, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	return b.store.children(path)
+}
+
+// ChildrenW returns node children names and watches children changes
+func (b *MemoryBackend) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	children, stat, err := b.store.children(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return children, stat, b.store.addWatch(b.store.childWatches, b, path), nil
+}
+
+// Multi applies every operation or none of them
+func (b *MemoryBackend) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.store.multi(b, ops)
+}
+
+func (b *MemoryBackend) check() error {
+	switch {
+	case b.closed:
+		return zk.ErrClosing
+	case !b.connected:
+		return zk.ErrNoServer
+	case b.suspended:
+		return zk.ErrConnectionClosed
+	}
+	return nil
+}
+
+func (b *MemoryBackend) emit(state zk.State) {
+	select {
+	case b.events <- zk.Event{Type: zk.EventSession, State: state, Server: "memory"}:
+	default:
+	}
+}
+
+// deliver sends watch event, while suspended events are held until Resume
+func (b *MemoryBackend) deliver(ch chan zk.Event, event zk.Event) {
+	if b.suspended {
+		b.pending = append(b.pending, pendingWatchEvent{ch: ch, event: event})
+		return
+	}
+	ch <- event
+	close(ch)
+}
+
+func (b *MemoryBackend) flushPending() {
+	for _, p := range b.pending {
+		p.ch <- p.event
+		close(p.ch)
+	}
+	b.pending = nil
+}
+
+func (s *MemoryStore) newSession(b *MemoryBackend) {
+	s.lastSessionID++
+	b.sessionID = s.lastSessionID
+	s.sessions[b.sessionID] = b
+	b.emit(zk.StateHasSession)
+}
+
+// closeSession removes session watches and ephemeral nodes, watches
+// receive zk.EventNotWatching with err
+func (s *MemoryStore) closeSession(b *MemoryBackend, err error) {
+	sessionID := b.sessionID
+	delete(s.sessions, sessionID)
+
+	for _, watches := range []map[string][]*memoryWatch{s.dataWatches, s.childWatches} {
+		for path, list := range watches {
+			kept := list[:0]
+			for _, w := range list {
+				if w.backend != b {
+					kept = append(kept, w)
+					continue
+				}
+				b.deliver(w.ch, zk.Event{Type: zk.EventNotWatching, State: zk.StateDisconnected, Path: path, Err: err})
+			}
+			if len(kept) == 0 {
+				delete(watches, path)
+			} else {
+				watches[path] = kept
+			}
+		}
+	}
+	// watches are gone before ephemeral nodes are removed, the session
+	// doesn't see its own nodes going away
+	var ephemerals []string
+	s.walk("/", s.root, func(path string, node *memoryNode) {
+		if node.stat.EphemeralOwner == sessionID {
+			ephemerals = append(ephemerals, path)
+		}
+	})
+	for _, path := range ephemerals {
+		s.delete(path, -1)
+	}
+}
+
+func (s *MemoryStore) walk(path string, node *memoryNode, fn func(string, *memoryNode)) {
+	fn(path, node)
+	for name, child := range node.children {
+		s.walk(joinPath(path, name), child, fn)
+	}
+}
+
+func (s *MemoryStore) lookup(path string) *memoryNode {
+	node := s.root
+	if path == "/" {
+		return node
+	}
+
+	for _, name := range strings.Split(path[1:], "/") {
+		node = node.children[name]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func (s *MemoryStore) create(b *MemoryBackend, path string, data []byte, flags int32) (string, error) {
+	sequential := flags&zk.FlagSequence == zk.FlagSequence
+	if err := validateMemoryPath(path, sequential); err != nil {
+		return "", err
+	}
+	if path == "/" {
+		return "", zk.ErrNodeExists
+	}
+
+	idx := strings.LastIndex(path, "/")
+	parentPath, name := path[:idx], path[idx+1:]
+	if parentPath == "" {
+		parentPath = "/"
+	}
+
+	parent := s.lookup(parentPath)
+	if parent == nil {
+		return "", zk.ErrNoNode
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+
+	if sequential {
+		name = fmt.Sprintf("%s%010d", name, parent.stat.Cversion)
+	}
+	if _, exists := parent.children[name]; exists {
+		return "", zk.ErrNodeExists
+	}
+
+	s.zxid++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	node := &memoryNode{
+		data:     append([]byte(nil), data...),
+		children: make(map[string]*memoryNode),
+	}
+	node.stat = zk.Stat{
+		Czxid:      s.zxid,
+		Mzxid:      s.zxid,
+		Pzxid:      s.zxid,
+		Ctime:      now,
+		Mtime:      now,
+		DataLength: int32(len(data)),
+	}
+	if flags&zk.FlagEphemeral == zk.FlagEphemeral {
+		node.stat.EphemeralOwner = b.sessionID
+	}
+
+	if s.undo != nil {
+		parentStat := parent.stat
+		s.undo = append(s.undo, func() {
+			delete(parent.children, name)
+			parent.stat = parentStat
+		})
+	}
+
+	parent.children[name] = node
+	parent.stat.Cversion++
+	parent.stat.NumChildren++
+	parent.stat.Pzxid = s.zxid
+
+	created := joinPath(parentPath, name)
+	s.fire(s.dataWatches, created, zk.EventNodeCreated)
+	s.fire(s.childWatches, parentPath, zk.EventNodeChildrenChanged)
+
+	return created, nil
+}
+
+func (s *MemoryStore) exists(path string) (bool, *zk.Stat, error) {
+	if err := validateMemoryPath(path, false); err != nil {
+		return false, nil, err
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return false, nil, nil
+	}
+	stat := node.stat
+	return true, &stat, nil
+}
+
+func (s *MemoryStore) get(path string) ([]byte, *zk.Stat, error) {
+	if err := validateMemoryPath(path, false); err != nil {
+		return nil, nil, err
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+	stat := node.stat
+	return append([]byte(nil), node.data...), &stat, nil
+}
+
+func (s *MemoryStore) set(path string, data []byte, version int32) (*zk.Stat, error) {
+	if err := validateMemoryPath(path, false); err != nil {
+		return nil, err
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return nil, zk.ErrBadVersion
+	}
+
+	if s.undo != nil {
+		oldData, oldStat := node.data, node.stat
+		s.undo = append(s.undo, func() {
+			node.data, node.stat = oldData, oldStat
+		})
+	}
+
+	s.zxid++
+	node.data = append([]byte(nil), data...)
+	node.stat.Version++
+	node.stat.Mzxid = s.zxid
+	node.stat.Mtime = time.Now().UnixNano() / int64(time.Millisecond)
+	node.stat.DataLength = int32(len(data))
+
+	s.fire(s.dataWatches, path, zk.EventNodeDataChanged)
+
+	stat := node.stat
+	return &stat, nil
+}
+
+func (s *MemoryStore) delete(path string, version int32) error {
+	if err := validateMemoryPath(path, false); err != nil {
+		return err
+	}
+	if path == "/" {
+		return zk.ErrAPIError
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return zk.ErrBadVersion
+	}
+	if len(node.children) > 0 {
+		return zk.ErrNotEmpty
+	}
+
+	idx := strings.LastIndex(path, "/")
+	parentPath := path[:idx]
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	parent := s.lookup(parentPath)
+
+	if s.undo != nil {
+		name, parentStat := path[idx+1:], parent.stat
+		s.undo = append(s.undo, func() {
+			parent.children[name] = node
+			parent.stat = parentStat
+		})
+	}
+
+	s.zxid++
+	delete(parent.children, path[idx+1:])
+	parent.stat.Cversion++
+	parent.stat.NumChildren--
+	parent.stat.Pzxid = s.zxid
+
+	s.fire(s.dataWatches, path, zk.EventNodeDeleted)
+	s.fire(s.childWatches, path, zk.EventNodeDeleted)
+	s.fire(s.childWatches, parentPath, zk.EventNodeChildrenChanged)
+
+	return nil
+}
+
+// checkVersion fails unless node exists and version matches, -1 matches
+// any version
+func (s *MemoryStore) checkVersion(path string, version int32) error {
+	if err := validateMemoryPath(path, false); err != nil {
+		return err
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return zk.ErrBadVersion
+	}
+	return nil
+}
+
+// multi applies ops in order. When one fails the nodes changed so far are
+// restored and no watch fires, like zookeeper operations before the failing one report no
+// error and the ones after it report zk.ErrUnknown.
+func (s *MemoryStore) multi(b *MemoryBackend, ops []interface{}) ([]zk.MultiResponse, error) {
+	for _, op := range ops {
+		switch op.(type) {
+		case *zk.CreateRequest, *zk.SetDataRequest, *zk.DeleteRequest, *zk.CheckVersionRequest:
+		default:
+			return nil, fmt.Errorf("unknown operation type %T", op)
+		}
+	}
+
+	zxid := s.zxid
+	s.deferred = []deferredFire{}
+	s.undo = []func(){}
+	defer func() {
+		s.deferred = nil
+		s.undo = nil
+	}()
+
+	responses := make([]zk.MultiResponse, len(ops))
+	for idx, op := range ops {
+		var err error
+		switch op := op.(type) {
+		case *zk.CreateRequest:
+			responses[idx].String, err = s.create(b, op.Path, op.Data, op.Flags)
+		case *zk.SetDataRequest:
+			responses[idx].Stat, err = s.set(op.Path, op.Data, op.Version)
+		case *zk.DeleteRequest:
+			err = s.delete(op.Path, op.Version)
+		case *zk.CheckVersionRequest:
+			err = s.checkVersion(op.Path, op.Version)
+		}
+
+		if err != nil {
+			for i := len(s.undo) - 1; i >= 0; i-- {
+				s.undo[i]()
+			}
+			s.zxid = zxid
+			for failed := range responses {
+				switch {
+				case failed < idx:
+					responses[failed] = zk.MultiResponse{}
+				case failed == idx:
+					responses[failed] = zk.MultiResponse{Error: err}
+				default:
+					responses[failed] = zk.MultiResponse{Error: zk.ErrUnknown}
+				}
+			}
+			return responses, err
+		}
+	}
+
+	deferred := s.deferred
+	s.deferred = nil
+	for _, d := range deferred {
+		s.fire(d.watches, d.path, d.eventType)
+	}
+	return responses, nil
+}
+
+func (s *MemoryStore) children(path string) ([]string, *zk.Stat, error) {
+	if err := validateMemoryPath(path, false); err != nil {
+		return nil, nil, err
+	}
+
+	node := s.lookup(path)
+	if node == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+
+	// like zookeeper, children come in no particular order
+	children := make([]string, 0, len(node.children))
+	for name := range node.children {
+		children = append(children, name)
+	}
+	stat := node.stat
+	return children, &stat, nil
+}
+
+func (s *MemoryStore) addWatch(watches map[string][]*memoryWatch, b *MemoryBackend, path string) <-chan zk.Event {
+	w := &memoryWatch{backend: b, ch: make(chan zk.Event, 1)}
+	watches[path] = append(watches[path], w)
+	s.stats.Watches++
+	return w.ch
+}
+
+// fire triggers watches once, like zookeeper they must be set again
+func (s *MemoryStore) fire(watches map[string][]*memoryWatch, path string, eventType zk.EventType) {
+	if s.deferred != nil {
+		s.deferred = append(s.deferred, deferredFire{watches: watches, path: path, eventType: eventType})
+		return
+	}
+
+	list := watches[path]
+	delete(watches, path)
+
+	for _, w := range list {
+		s.stats.Events++
+		w.backend.deliver(w.ch, zk.Event{Type: eventType, State: zk.StateHasSession, Path: path})
+	}
+}
+
+func joinPath(parent, name string) string {
+	if parent == "/" {
+		return "/" + name
+	}
+	return parent + "/" + name
+}
+
+func validateMemoryPath(path string, sequential bool) error {
+	if path == "" || path[0] != '/' {
+		return zk.ErrInvalidPath
+	}
+	if path == "/" {
+		return nil
+	}
+	if !sequential && strings.HasSuffix(path, "/") {
+		return zk.ErrInvalidPath
+	}
+	if strings.Contains(path, "//") || strings.Contains(path, "\x00") {
+		return zk.ErrInvalidPath
+	}
+	return nil
+}
+
+// NewMemoryStore returns new empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		root:         &memoryNode{children: make(map[string]*memoryNode)},
+		sessions:     make(map[int64]*MemoryBackend),
+		dataWatches:  make(map[string][]*memoryWatch),
+		childWatches: make(map[string][]*memoryWatch),
+	}
+}
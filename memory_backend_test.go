@@ -0,0 +1,203 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBackendNodes(t *testing.T) {
+	assert := assert.New(t)
+	backend := NewMemoryStore().NewBackend()
+	backend.Connect(time.Second)
+
+	_, err := backend.Create("/a/b", []byte{}, 0)
+	assert.Equal(err, zk.ErrNoNode)
+
+	path, err := backend.Create("/a", []byte("v1"), 0)
+	assert.Nil(err)
+	assert.Equal(path, "/a")
+
+	_, err = backend.Create("/a", []byte{}, 0)
+	assert.Equal(err, zk.ErrNodeExists)
+
+	seq01, _ := backend.Create("/a/n-", []byte{}, zk.FlagSequence)
+	seq02, _ := backend.Create("/a/n-", []byte{}, zk.FlagSequence)
+	assert.Equal(seq01, "/a/n-0000000000")
+	assert.Equal(seq02, "/a/n-0000000001")
+
+	_, err = backend.Set("/a", []byte("v2"), 1)
+	assert.Equal(err, zk.ErrBadVersion)
+
+	stat, err := backend.Set("/a", []byte("v2"), 0)
+	assert.Nil(err)
+	assert.Equal(stat.Version, int32(1))
+
+	data, _, _ := backend.Get("/a")
+	assert.Equal(data, []byte("v2"))
+
+	assert.Equal(backend.Delete("/a", -1), zk.ErrNotEmpty)
+	assert.Nil(backend.Delete(seq01, -1))
+	assert.Nil(backend.Delete(seq02, -1))
+	assert.Nil(backend.Delete("/a", -1))
+
+	backend.Close()
+}
+
+func TestMemoryBackendMultiRollback(t *testing.T) {
+	assert := assert.New(t)
+	backend := NewMemoryStore().NewBackend()
+	backend.Connect(time.Second)
+
+	backend.Create("/m", []byte("v0"), 0)
+	backend.Create("/m/old", []byte{}, 0)
+	_, before, _ := backend.Exists("/m")
+	_, last, _ := backend.Exists("/m/old")
+
+	_, err := backend.Multi(
+		&zk.SetDataRequest{Path: "/m", Data: []byte("v1"), Version: 0},
+		&zk.DeleteRequest{Path: "/m/old", Version: -1},
+		&zk.CreateRequest{Path: "/m/new", Data: []byte{}},
+		&zk.DeleteRequest{Path: "/m/new", Version: -1},
+		&zk.CreateRequest{Path: "/m/new", Data: []byte{}},
+		&zk.CheckVersionRequest{Path: "/m", Version: 0},
+	)
+	assert.Equal(err, zk.ErrBadVersion)
+
+	data, stat, _ := backend.Get("/m")
+	assert.Equal(data, []byte("v0"))
+	assert.Equal(*stat, *before)
+	children, _, _ := backend.Children("/m")
+	assert.Equal(children, []string{"old"})
+
+	// the next change continues from the restored tree
+	stat, err = backend.Set("/m", []byte("v1"), 0)
+	assert.Nil(err)
+	assert.Equal(stat.Mzxid, last.Czxid+1)
+
+	assert.Nil(backend.Delete("/m/old", -1))
+	assert.Nil(backend.Delete("/m", -1))
+	backend.Close()
+}
+
+func TestMemoryBackendConnectAfterClose(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	backend := store.NewBackend()
+	backend.Connect(time.Second)
+
+	path, _ := backend.Create("/e", []byte{}, zk.FlagEphemeral)
+	sessionID := backend.SessionID()
+	backend.Close()
+
+	_, err := backend.Create("/e", []byte{}, 0)
+	assert.Equal(err, zk.ErrClosing)
+
+	events, err := backend.Connect(time.Second)
+	assert.Nil(err)
+	assert.Equal((<-events).State, zk.StateConnecting)
+	assert.NotEqual(backend.SessionID(), sessionID)
+
+	exists, _, err := backend.Exists(path)
+	assert.Nil(err)
+	assert.False(exists)
+
+	backend.Close()
+}
+
+func TestMemoryBackendWatches(t *testing.T) {
+	assert := assert.New(t)
+	backend := NewMemoryStore().NewBackend()
+	backend.Connect(time.Second)
+
+	_, _, existsWatch, _ := backend.ExistsW("/w")
+	backend.Create("/w", []byte{}, 0)
+	assert.Equal((<-existsWatch).Type, zk.EventNodeCreated)
+
+	_, _, childWatch, _ := backend.ChildrenW("/w")
+	_, _, dataWatch, _ := backend.GetW("/w")
+	backend.Create("/w/c", []byte{}, 0)
+	backend.Set("/w", []byte("x"), -1)
+	assert.Equal((<-childWatch).Type, zk.EventNodeChildrenChanged)
+	assert.Equal((<-dataWatch).Type, zk.EventNodeDataChanged)
+
+	backend.Close()
+}
+
+func TestMemoryBackendSessionExpire(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	owner := store.NewBackend()
+	owner.Connect(time.Second)
+	other := store.NewBackend()
+	other.Connect(time.Second)
+
+	owner.Create("/s", []byte{}, 0)
+	path, _ := owner.CreateProtectedEphemeralSequential("/s/", []byte{})
+	_, _, watch, _ := other.ExistsW(path)
+
+	sessionID := owner.SessionID()
+	store.ExpireSession(sessionID)
+
+	assert.Equal((<-watch).Type, zk.EventNodeDeleted)
+	exists, _, _ := other.Exists(path)
+	assert.False(exists)
+	assert.NotEqual(owner.SessionID(), sessionID)
+
+	owner.Close()
+	other.Close()
+}
+
+func TestClientConnectionStates(t *testing.T) {
+	assert := assert.New(t)
+	backend := testStore.NewBackend()
+	states := make(chan ConnectionState, 10)
+
+	client := NewClient(
+		SetBackend(backend),
+		SetConnectionStateCallback(func(state ConnectionState) {
+			states <- state
+		}),
+	)
+	assert.Nil(client.Connect())
+	assert.Equal(<-states, ConnectionStateConnected)
+
+	backend.Suspend()
+	assert.Equal(<-states, ConnectionStateSuspended)
+	backend.Resume()
+	assert.Equal(<-states, ConnectionStateReconnected)
+
+	backend.Expire()
+	assert.Equal(<-states, ConnectionStateSuspended)
+	assert.Equal(<-states, ConnectionStateExpired)
+	assert.Equal(<-states, ConnectionStateConnected)
+
+	client.Disconnect()
+}
+
+func TestElectionReRegisterAfterExpire(t *testing.T) {
+	assert := assert.New(t)
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	client.Connect()
+
+	election := NewRoleSelector(client, "/supervisor/test/election/expire")
+	revoked := make(chan RoleChange, 1)
+	election.OnRevoked(func(change RoleChange) {
+		revoked <- change
+	})
+	election.Start()
+	<-election.IsMaster
+
+	backend.Expire()
+	assert.Equal((<-revoked).To, NodeRoleSlave)
+
+	// a new node is registered with the new session
+	<-election.IsMaster
+	assert.Equal(election.Role, NodeRoleMaster)
+
+	election.Stop()
+	client.Disconnect()
+}
@@ -0,0 +1,300 @@
+package supervisor
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// messagingRootPath holds every node entry and inbox, apart from
+	// application paths
+	messagingRootPath  = "/supervisor/messaging"
+	messagingNodesPath = "nodes"
+	messagingInboxPath = "inbox"
+	messagePrefix      = "msg-"
+)
+
+// GUID returns current node id, other nodes use it to send messages
+func (c *Client) GUID() string {
+	return c.guid
+}
+
+// Nodes returns guid of every node able to receive messages
+func (c *Client) Nodes() ([]string, error) {
+	var nodes []string
+	err := c.ops(nil).retry(func() (err error) {
+		nodes, _, err = c.backend.Children(c.messagingPath(messagingNodesPath))
+		return err
+	})
+	if err == zk.ErrNoNode {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// SendMessage sends payload to node inbox, messages are received in
+// the same order they are sent. It fails when the node is gone, a message
+// could be sent twice when the connection fails while it's sent.
+func (c *Client) SendMessage(nodeGUID string, payload []byte) error {
+	node := c.messagingPath(messagingNodesPath, nodeGUID)
+	inbox := c.messagingPath(messagingInboxPath, nodeGUID)
+
+	ops := c.ops(nil)
+	err := ops.retry(func() error {
+		// node entry is checked with the create, so dead nodes get nothing
+		_, err := ops.txn().
+			Check(node, -1).
+			Create(inbox+"/"+messagePrefix, payload, zk.FlagSequence).
+			Commit()
+		return err
+	})
+	if err == zk.ErrNoNode {
+		return fmt.Errorf("Node %s not found", nodeGUID)
+	}
+	return err
+}
+
+// Broadcast sends payload to every other node able to receive messages
+func (c *Client) Broadcast(payload []byte) error {
+	nodes, err := c.Nodes()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, nodeGUID := range nodes {
+		if nodeGUID == c.guid {
+			continue
+		}
+		if err := c.SendMessage(nodeGUID, payload); err != nil {
+			c.logger.Errorf("Could not send message to %s - %s", nodeGUID, err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) messagingPath(parts ...string) string {
+	path := messagingRootPath
+	for _, part := range parts {
+		path += "/" + part
+	}
+	return path
+}
+
+// registerInbox announces the node and creates its inbox. The node entry
+// is ephemeral, the inbox is removed by Disconnect.
+func (c *Client) registerInbox() error {
+	ops := c.ops(nil)
+
+	inbox := c.messagingPath(messagingInboxPath, c.guid)
+	if _, err := ops.createParentNodeIfNotExists(inbox, []byte{}); err != nil {
+		return err
+	}
+
+	nodes := c.messagingPath(messagingNodesPath)
+	if _, err := ops.createParentNodeIfNotExists(nodes, []byte{}); err != nil {
+		return err
+	}
+
+	// a new attempt finds the node created by the one going through
+	err := ops.retry(func() error {
+		_, err := c.backend.Create(nodes+"/"+c.guid, []byte{}, zk.FlagEphemeral)
+		if err == zk.ErrNodeExists {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.reapInboxes(); err != nil {
+		c.logger.Errorf("Could not remove inboxes left - %s", err.Error())
+	}
+	return nil
+}
+
+// reapInboxes removes the inboxes of nodes gone without Disconnect, their
+// node entry went away with the session
+func (c *Client) reapInboxes() error {
+	nodes, err := c.Nodes()
+	if err != nil {
+		return err
+	}
+
+	alive := make(map[string]bool, len(nodes))
+	for _, nodeGUID := range nodes {
+		alive[nodeGUID] = true
+	}
+
+	ops := c.ops(nil)
+
+	var inboxes []string
+	err = ops.retry(func() (err error) {
+		inboxes, _, err = c.backend.Children(c.messagingPath(messagingInboxPath))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, nodeGUID := range inboxes {
+		if alive[nodeGUID] || nodeGUID == c.guid {
+			continue
+		}
+
+		inbox := c.messagingPath(messagingInboxPath, nodeGUID)
+		var messages []string
+		err := ops.retry(func() (err error) {
+			messages, _, err = c.backend.Children(inbox)
+			return err
+		})
+		if err == zk.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// not retried, an inbox left behind is reaped by the next node connecting
+		txn := ops.txn()
+		for _, message := range messages {
+			txn.Delete(inbox+"/"+message, -1)
+		}
+		txn.Delete(inbox, -1)
+
+		// someone else removed it or the node came back meanwhile
+		if _, err := txn.Commit(); err != nil && err != zk.ErrNoNode && err != zk.ErrNotEmpty {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveMessages delivers inbox messages to the callback in order, each
+// message is deleted once the callback returns
+func (c *Client) receiveMessages() {
+	states, unsubscribe := c.subscribeConnectionState()
+	defer unsubscribe()
+
+	ops := c.ops(nil)
+	inbox := c.messagingPath(messagingInboxPath, c.guid)
+
+	for {
+		var channel <-chan zk.Event
+
+		children, _, ch, err := ops.childrenWatch(inbox)
+		if err == zk.ErrNoNode && c.isConnected() && !c.disconnecting() {
+			// removed by another node while our node entry was gone
+			if err := c.registerInbox(); err != nil {
+				c.logger.Errorf("Could not register inbox %s - %s", inbox, err.Error())
+			} else {
+				continue
+			}
+		}
+		if err != nil {
+			c.logger.Errorf("Could not read inbox %s - %s", inbox, err.Error())
+		} else {
+			channel = ch
+			c.deliverMessages(inbox, children)
+		}
+
+		select {
+		case <-channel:
+		case state := <-states:
+			if state == ConnectionStateConnected {
+				// node entry went away with the previous session
+				if err := c.registerInbox(); err != nil {
+					c.logger.Errorf("Could not register inbox %s - %s", inbox, err.Error())
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) deliverMessages(inbox string, messages []string) {
+	ops := c.ops(nil)
+	sort.Sort(ByNodeGUID(messages))
+
+	for _, message := range messages {
+		path := inbox + "/" + message
+
+		data, _, err := ops.get(path)
+		if err != nil {
+			c.logger.Errorf("Could not read message %s - %s", path, err.Error())
+			return
+		}
+
+		c.currentReceiveMessageCallback(data)
+
+		// acknowledge, a new attempt after one going through finds no node
+		if err := ops.deleteNode(path, -1); err != nil && err != zk.ErrNoNode {
+			c.logger.Errorf("Could not remove message %s - %s", path, err.Error())
+			return
+		}
+	}
+}
+
+// removeInbox deletes node entry, pending messages and the inbox
+func (c *Client) removeInbox() error {
+	node := c.messagingPath(messagingNodesPath, c.guid)
+	inbox := c.messagingPath(messagingInboxPath, c.guid)
+
+	// node entry, messages left and inbox go away together, it's tried
+	// again when messages arrive or are delivered meanwhile. The commit
+	// itself isn't retried, the loop reads everything again instead.
+	ops := c.ops(nil)
+	for {
+		txn := ops.txn()
+
+		var exists bool
+		err := ops.retry(func() (err error) {
+			exists, _, err = c.backend.Exists(node)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if exists {
+			txn.Delete(node, -1)
+		}
+
+		var messages []string
+		err = ops.retry(func() (err error) {
+			messages, _, err = c.backend.Children(inbox)
+			return err
+		})
+		if err != nil && err != zk.ErrNoNode {
+			return err
+		}
+		if err == nil {
+			for _, message := range messages {
+				txn.Delete(inbox+"/"+message, -1)
+			}
+			txn.Delete(inbox, -1)
+		}
+
+		if _, err := txn.Commit(); err != zk.ErrNotEmpty && err != zk.ErrNoNode {
+			return err
+		}
+	}
+}
+
+func newNodeGUID() (string, error) {
+	var guid [16]byte
+	if _, err := rand.Read(guid[:]); err != nil {
+		return "", errors.New("Could not create node guid: " + err.Error())
+	}
+	return fmt.Sprintf("%x", guid), nil
+}
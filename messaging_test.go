@@ -0,0 +1,167 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMessagingClient(received chan []byte) *Client {
+	c := NewClient(
+		SetBackend(testStore.NewBackend()),
+		SetNodeReceiveMessageCallback(func(payload []byte) {
+			received <- payload
+		}),
+	)
+	c.Connect()
+	return c
+}
+
+func TestMessagingSendInOrder(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan []byte, 10)
+	sender := newTestClient()
+	receiver := newTestMessagingClient(received)
+
+	for _, payload := range []string{"cmd01", "cmd02", "cmd03"} {
+		assert.Nil(sender.SendMessage(receiver.GUID(), []byte(payload)))
+	}
+
+	assert.Equal(string(<-received), "cmd01")
+	assert.Equal(string(<-received), "cmd02")
+	assert.Equal(string(<-received), "cmd03")
+
+	assert.NotNil(sender.SendMessage("unknown", []byte("cmd")))
+
+	receiver.Disconnect()
+	sender.Disconnect()
+}
+
+func TestMessagingBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan []byte, 10)
+	clients := []*Client{
+		newTestMessagingClient(received),
+		newTestMessagingClient(received),
+		newTestMessagingClient(received),
+	}
+
+	nodes, _ := clients[0].Nodes()
+	assert.Len(nodes, 3)
+
+	assert.Nil(clients[0].Broadcast([]byte("reload")))
+	assert.Equal(string(<-received), "reload")
+	assert.Equal(string(<-received), "reload")
+	assert.Len(received, 0)
+
+	closeClients(clients)
+}
+
+func TestMessagingDeadNodeInboxReaped(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	received := make(chan []byte, 10)
+	newClient := func() *Client {
+		c := NewClient(
+			SetBackend(store.NewBackend()),
+			SetNodeReceiveMessageCallback(func(payload []byte) {
+				received <- payload
+			}),
+		)
+		c.Connect()
+		return c
+	}
+
+	sender := newClient()
+	crashed := newClient()
+	inbox := messagingRootPath + "/" + messagingInboxPath + "/" + crashed.GUID()
+
+	// kept apart from application paths
+	exists, _, _ := sender.backend.Exists(inbox)
+	assert.True(exists)
+
+	// gone without Disconnect, the inbox is left behind
+	crashed.backend.Close()
+	exists, _, _ = sender.backend.Exists(inbox)
+	assert.True(exists)
+	assert.EqualError(sender.SendMessage(crashed.GUID(), []byte("cmd")), "Node "+crashed.GUID()+" not found")
+
+	// removed by the next node connecting
+	other := newClient()
+	exists, _, _ = sender.backend.Exists(inbox)
+	assert.False(exists)
+
+	assert.Nil(sender.SendMessage(other.GUID(), []byte("cmd")))
+	assert.Equal(string(<-received), "cmd")
+
+	other.Disconnect()
+	sender.Disconnect()
+}
+
+// inboxRefusingBackend refuses to create the node entry of the inbox
+type inboxRefusingBackend struct {
+	*MemoryBackend
+}
+
+func (b *inboxRefusingBackend) Create(path string, data []byte, flags int32) (string, error) {
+	if flags&zk.FlagEphemeral == zk.FlagEphemeral {
+		return "", zk.ErrNoAuth
+	}
+	return b.MemoryBackend.Create(path, data, flags)
+}
+
+func TestMessagingConnectInboxError(t *testing.T) {
+	assert := assert.New(t)
+	backend := &inboxRefusingBackend{testStore.NewBackend()}
+	client := NewClient(
+		SetBackend(backend),
+		SetNodeReceiveMessageCallback(func(payload []byte) {}),
+	)
+
+	assert.Equal(client.Connect(), zk.ErrNoAuth)
+
+	// the session isn't left open
+	_, _, err := backend.Exists("/")
+	assert.Equal(err, zk.ErrClosing)
+
+	client.Disconnect()
+}
+
+func TestDisconnectTwice(t *testing.T) {
+	assert := assert.New(t)
+
+	// never connected
+	NewClient(SetBackend(testStore.NewBackend())).Disconnect()
+
+	client := newTestClient()
+	assert.NotPanics(client.Disconnect)
+	assert.NotPanics(client.Disconnect)
+}
+
+func TestMessagingRetryConnectionErrors(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan []byte, 10)
+	backend := testStore.NewBackend()
+	sender := NewClient(
+		SetBackend(backend),
+		SetRetryPolicy(NewForeverRetry(10*time.Millisecond)),
+	)
+	assert.Nil(sender.Connect())
+	receiver := newTestMessagingClient(received)
+
+	backend.Suspend()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		backend.Resume()
+	}()
+
+	// client policy keeps trying until the connection is back
+	nodes, err := sender.Nodes()
+	assert.Nil(err)
+	assert.Contains(nodes, receiver.GUID())
+
+	receiver.Disconnect()
+	sender.Disconnect()
+}
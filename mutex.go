@@ -1,88 +1,339 @@
 package supervisor
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 )
 
-// Mutex holds mutex information
+// ErrMutexAlreadyLocked returned by a non-reentrant mutex acquired twice
+var ErrMutexAlreadyLocked = errors.New("Mutex already locked")
+
+// MutexOptionsFunc mutex definition
+type MutexOptionsFunc func(*Mutex)
+
+// SetMutexNonReentrant makes Acquire fail with ErrMutexAlreadyLocked when
+// the mutex is already held, instead of incrementing the hold count
+func SetMutexNonReentrant() MutexOptionsFunc {
+	return func(m *Mutex) {
+		m.reentrant = false
+	}
+}
+
+// SetMutexRetryPolicy overrides client retry policy for this mutex
+func SetMutexRetryPolicy(policy RetryPolicy) MutexOptionsFunc {
+	return func(m *Mutex) {
+		m.ops.policy = policy
+	}
+}
+
+// Mutex holds mutex information. It's reentrant by default, acquiring it
+// again while held increments the hold count and the lock is only
+// released when the count gets back to zero. The owner of the lock is
+// the *Mutex value, not the goroutine: goroutines sharing one *Mutex
+// don't exclude each other, share it only within one logical owner and
+// give every other owner its own NewMutex.
 type Mutex struct {
-	client   *Client
-	key      string
-	path     string
-	lockPath string
-	guid     string
-	locked   bool
+	client    *Client
+	ops       *clientOps
+	key       string
+	path      string
+	lockPath  string
+	guid      string
+	locked    bool
+	reentrant bool
+	holdCount int
+	token     FencingToken
+
+	mu          sync.Mutex
+	lost        chan struct{}
+	unsubscribe func()
+
+	// acquiring is closed when the running acquire returns, other callers
+	// wait for it instead of queueing a second node
+	acquiring chan struct{}
 }
 
-// Acquire blocks until it's available
+// Acquire blocks until it's available or waitTime elapses
 func (m *Mutex) Acquire(waitTime int64, unit time.Duration) error {
-	if !m.client.isConnected {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(waitTime)*unit)
+	defer cancel()
+
+	if err := m.AcquireContext(ctx); err != nil {
+		if err == context.DeadlineExceeded {
+			return errors.New("Timeout")
+		}
+		return err
+	}
+	return nil
+}
+
+// AcquireContext blocks until it's available or ctx is done. When ctx is
+// done the queued node is removed and ctx.Err() is returned.
+func (m *Mutex) AcquireContext(ctx context.Context) error {
+	m.mu.Lock()
+	for m.acquiring != nil {
+		acquiring := m.acquiring
+		m.mu.Unlock()
+		select {
+		case <-acquiring:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		m.mu.Lock()
+	}
+	if m.locked {
+		defer m.mu.Unlock()
+		if !m.reentrant {
+			return ErrMutexAlreadyLocked
+		}
+		m.holdCount++
+		return nil
+	}
+	acquiring := make(chan struct{})
+	m.acquiring = acquiring
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.acquiring = nil
+		close(acquiring)
+		m.mu.Unlock()
+	}()
+
+	if !m.client.isConnected() {
 		return errors.New("Client not connected")
 	}
 
-	_, err := m.client.createParentNodeIfNotExists(m.path, []byte{})
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	abspath, guid, err := m.client.createProtectedEphemeralSequential(m.path, []byte{})
-	if err != nil {
-		return fmt.Errorf("%s - %s", err.Error(), m.path)
+	states, unsubscribe := m.client.subscribeConnectionState()
+
+	if err := m.enqueue(); err != nil {
+		unsubscribe()
+		return err
 	}
 
-	m.lockPath = abspath
-	m.guid = guid
+	for {
+		var channel <-chan zk.Event
+
+		if m.lockPath != "" {
+			children, err := m.ops.getSortedNodeGUIDList(m.path)
+			if err == zk.ErrNoNode {
+				// the lock node went away with ours
+				children, err = nil, nil
+			}
+			if err != nil {
+				unsubscribe()
+				return fmt.Errorf("%s - %s", err.Error(), m.path)
+			}
 
-	for !m.locked {
-		children, _, channel, err := m.client.childrenWatch(m.path)
-		if err != nil {
-			return fmt.Errorf("%s - %s", err.Error(), m.path)
+			idx := indexOfGUID(children, m.guid)
+			if idx < 0 {
+				// our node is gone, take a new place in the queue
+				m.guid = ""
+				m.lockPath = ""
+				if m.client.isConnected() {
+					if err := m.enqueue(); err != nil {
+						unsubscribe()
+						return err
+					}
+					continue
+				}
+			} else if idx == 0 {
+				break
+			} else {
+				// only the node right ahead of ours can hand over the lock
+				exists, _, ch, err := m.ops.existsWatch(m.path + "/" + children[idx-1])
+				if err != nil {
+					unsubscribe()
+					return fmt.Errorf("%s - %s", err.Error(), m.path)
+				}
+				if !exists {
+					continue
+				}
+				channel = ch
+			}
 		}
 
-		if len(children) == 1 {
-			m.locked = true
-			break
+		select {
+		case <-ctx.Done():
+			unsubscribe()
+			if m.lockPath != "" {
+				if err := m.ops.deleteNodeLastVersion(m.lockPath); err != nil {
+					return fmt.Errorf("Could not remove node %s - %s", m.path, err.Error())
+				}
+			}
+			m.guid = ""
+			m.lockPath = ""
+			return ctx.Err()
+		case <-channel:
+		case state := <-states:
+			switch state {
+			case ConnectionStateExpired:
+				// our place in the queue went away with the session
+				if !m.ops.ownsNode(m.lockPath) {
+					m.guid = ""
+					m.lockPath = ""
+				}
+			case ConnectionStateConnected:
+				if m.lockPath == "" {
+					if err := m.enqueue(); err != nil {
+						unsubscribe()
+						return err
+					}
+				}
+			}
 		}
+	}
 
-		timeout := make(chan bool, 0)
-		go func() {
-			time.Sleep(time.Duration(waitTime) * unit)
-			timeout <- true
-		}()
+	token, err := m.ops.fencingToken(m.lockPath)
+	if err != nil {
+		unsubscribe()
+		lockPath := m.lockPath
+		if errDelete := m.ops.deleteNodeLastVersion(lockPath); errDelete != nil {
+			m.client.logger.Errorf("Could not remove node %s - %s", lockPath, errDelete.Error())
+		}
+		m.guid = ""
+		m.lockPath = ""
+		return fmt.Errorf("Could not read fencing token %s - %s", lockPath, err.Error())
+	}
+
+	m.mu.Lock()
+	m.locked = true
+	m.holdCount = 1
+	m.token = token
+	m.lost = make(chan struct{})
+	m.unsubscribe = unsubscribe
+	m.mu.Unlock()
+
+	go m.watchConnection(states, m.lost)
+
+	return nil
+}
+
+// enqueue creates the sequential node that holds our place in the lock
+// queue, together with the lock node when it's missing
+func (m *Mutex) enqueue() error {
+	abspath, guid, err := m.ops.createProtectedEphemeralSequential(m.path, []byte{})
+	if err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), m.path)
+	}
+
+	m.lockPath = abspath
+	m.guid = guid
+	return nil
+}
 
+// watchConnection marks the mutex as not locked when the session
+// holding the lock node is lost or expires
+func (m *Mutex) watchConnection(states <-chan ConnectionState, lost chan struct{}) {
+	for {
 		select {
-		case <-timeout:
-			if err := m.client.deleteNodeLastVersion(m.lockPath); err != nil {
-				return fmt.Errorf("Could not remove node %s - %s", m.path, err.Error())
+		case state := <-states:
+			if state != ConnectionStateLost && state != ConnectionStateExpired {
+				continue
 			}
-			return errors.New("Timeout")
-		case event := <-channel:
-			if event.Type == zk.EventNodeChildrenChanged {
-				nodeGUIDList, err := m.client.getSortedNodeGUIDList(m.path)
-				if err != nil {
-					return err
-				}
 
-				if nodeGUIDList[0] == m.guid {
-					m.locked = true
-					break
+			m.mu.Lock()
+			if m.lost != lost {
+				m.mu.Unlock()
+				return
+			}
+			m.client.logger.Errorf("Lock %s lost: connection %s", m.path, state)
+			m.locked = false
+			m.holdCount = 0
+			m.token = 0
+			close(lost)
+			lockPath := m.lockPath
+			unsubscribe := m.unsubscribe
+			m.unsubscribe = nil
+			m.mu.Unlock()
+
+			if state == ConnectionStateLost {
+				m.removeLostNode(states, lockPath)
+			}
+			unsubscribe()
+			return
+		case <-lost:
+			return
+		}
+	}
+}
+
+// removeLostNode removes the node of a lost lock when the connection comes
+// back with the same session, it would block every waiter otherwise. When
+// the session expires the node is already gone.
+func (m *Mutex) removeLostNode(states <-chan ConnectionState, lockPath string) {
+	for {
+		select {
+		case state := <-states:
+			switch state {
+			case ConnectionStateReconnected:
+				if err := m.ops.deleteNodeLastVersion(lockPath); err != nil {
+					m.client.logger.Errorf("Could not remove node %s - %s", lockPath, err.Error())
 				}
+				return
+			case ConnectionStateExpired, ConnectionStateConnected:
+				return
 			}
+		case <-m.ops.done:
+			return
 		}
 	}
+}
 
-	return nil
+// IsLocked returns true while this mutex holds the lock
+func (m *Mutex) IsLocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locked
+}
+
+// Lost returns a channel closed when the lock is lost because of the
+// connection state. It's closed on Release too.
+func (m *Mutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// HoldCount returns how many times the lock is held by this mutex
+func (m *Mutex) HoldCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.holdCount
 }
 
-// Release performs one release of the mutex
+// FencingToken returns the token of the current lock, it's zero while
+// the lock is not held. Pass it along with every write protected by the
+// lock, so a FencingValidator or FencingGuard can reject old holders.
+func (m *Mutex) FencingToken() FencingToken {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+// Release performs one release of the mutex, the lock node is removed
+// when the hold count gets to zero
 func (m *Mutex) Release() error {
+	m.mu.Lock()
 	if !m.locked {
+		m.mu.Unlock()
 		return errors.New("Key [" + m.key + "] not locked")
 	}
+	if m.holdCount > 1 {
+		m.holdCount--
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
 
 	if err := m.cleanup(); err != nil {
 		return err
@@ -92,13 +343,29 @@ func (m *Mutex) Release() error {
 }
 
 func (m *Mutex) cleanup() error {
-	if err := m.client.deleteNodeLastVersion(m.lockPath); err != nil {
-		return fmt.Errorf("Could not remove node %s - %s", m.path, err.Error())
+	m.mu.Lock()
+	m.locked = false
+	m.holdCount = 0
+	m.token = 0
+	if m.lost != nil {
+		select {
+		case <-m.lost:
+		default:
+			close(m.lost)
+		}
+	}
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+		m.unsubscribe = nil
 	}
+	m.mu.Unlock()
 
-	m.locked = false
+	if err := m.ops.deleteNodeLastVersion(m.lockPath); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", m.path, err.Error())
+	}
 
-	if err := m.client.deleteNodeLastVersion(m.path); err != nil {
+	// other clients may be queued on the same path
+	if err := m.ops.deleteNodeLastVersion(m.path); err != nil && err != zk.ErrNotEmpty {
 		return err
 	}
 
@@ -108,11 +375,18 @@ func (m *Mutex) cleanup() error {
 }
 
 // NewMutex returns new mutex for distributed lock
-func NewMutex(c *Client, path string) *Mutex {
+func NewMutex(c *Client, path string, options ...MutexOptionsFunc) *Mutex {
 	m := Mutex{
-		client: c,
-		path:   path,
-		locked: false,
+		client:    c,
+		ops:       c.ops(nil),
+		path:      path,
+		locked:    false,
+		reentrant: true,
 	}
+
+	for _, option := range options {
+		option(&m)
+	}
+
 	return &m
 }
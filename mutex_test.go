@@ -1,9 +1,14 @@
 package supervisor
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,3 +51,243 @@ func TestMutexMultipleLockAndReleasePrev(t *testing.T) {
 
 	closeClients(clients)
 }
+
+func TestMutexReentrant(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/mutex/key03"
+
+	lock := NewMutex(clients[0], lockPath)
+	assert.Equal(lock.Acquire(1, time.Second), nil)
+	assert.Equal(lock.Acquire(1, time.Second), nil)
+	assert.Equal(lock.HoldCount(), 2)
+
+	assert.Equal(lock.Release(), nil)
+	assert.True(lock.IsLocked())
+
+	other := NewMutex(clients[1], lockPath)
+	assert.Equal(other.Acquire(100, time.Millisecond).Error(), "Timeout")
+
+	assert.Equal(lock.Release(), nil)
+	assert.False(lock.IsLocked())
+	assert.Equal(other.Acquire(1, time.Second), nil)
+	assert.Equal(other.Release(), nil)
+
+	closeClients(clients)
+}
+
+func TestMutexNonReentrant(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(1)
+	lockPath := "/supervisor/test/mutex/key04"
+
+	lock := NewMutex(clients[0], lockPath, SetMutexNonReentrant())
+	assert.Equal(lock.Acquire(1, time.Second), nil)
+	assert.Equal(lock.Acquire(1, time.Second), ErrMutexAlreadyLocked)
+	assert.Equal(lock.Release(), nil)
+
+	closeClients(clients)
+}
+
+func TestMutexLostNodeRemovedOnReconnect(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/mutex/key05"
+
+	lock := NewMutex(clients[0], lockPath)
+	assert.Equal(lock.Acquire(1, time.Second), nil)
+	lost := lock.Lost()
+
+	// suspended for longer than the session timeout, but the session survives
+	clients[0].setConnectionState(ConnectionStateSuspended)
+	clients[0].setConnectionState(ConnectionStateLost)
+	<-lost
+	assert.False(lock.IsLocked())
+	assert.NotEqual(lock.Release(), nil)
+
+	// the lock node is removed once the connection comes back
+	clients[0].setConnectionState(ConnectionStateReconnected)
+	other := NewMutex(clients[1], lockPath)
+	assert.Equal(other.Acquire(1, time.Second), nil)
+	assert.Equal(other.Release(), nil)
+
+	closeClients(clients)
+}
+
+func TestMutexReEnqueueAfterExpire(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/mutex/key06"
+
+	holderClient := newTestClient()
+	backend := testStore.NewBackend()
+	waiterClient := NewClient(SetBackend(backend))
+	assert.Equal(waiterClient.Connect(), nil)
+
+	holder := NewMutex(holderClient, lockPath)
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+
+	waiter := NewMutex(waiterClient, lockPath)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- waiter.Acquire(2, time.Second)
+	}()
+
+	// the waiter takes a new place in the queue with the new session
+	time.Sleep(50 * time.Millisecond)
+	backend.Expire()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(holder.Release(), nil)
+	assert.Equal(<-acquired, nil)
+	assert.True(waiter.IsLocked())
+	assert.Equal(waiter.Release(), nil)
+
+	// the holder loses the lock when its session expires
+	assert.Equal(waiter.Acquire(1, time.Second), nil)
+	lost := waiter.Lost()
+	backend.Expire()
+	<-lost
+	assert.False(waiter.IsLocked())
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+	assert.Equal(holder.Release(), nil)
+
+	holderClient.Disconnect()
+	waiterClient.Disconnect()
+}
+
+func TestMutexAcquireContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/mutex/key07"
+
+	holder := NewMutex(clients[0], lockPath)
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+
+	waiter := NewMutex(clients[1], lockPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(waiter.AcquireContext(ctx), context.Canceled)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- waiter.AcquireContext(ctx)
+	}()
+
+	assert.Eventually(func() bool {
+		children, _, _ := clients[0].backend.Children(lockPath)
+		return len(children) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(<-acquired, context.Canceled)
+	assert.False(waiter.IsLocked())
+
+	// the waiter node is removed
+	children, _, err := clients[0].backend.Children(lockPath)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 1)
+
+	assert.Equal(holder.Release(), nil)
+	closeClients(clients)
+}
+
+func TestMutexReentrantConcurrentAcquire(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/mutex/key08"
+
+	holder := NewMutex(clients[1], lockPath)
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+
+	// goroutines sharing the mutex wait for the same lock node
+	lock := NewMutex(clients[0], lockPath)
+	const callers = 5
+	acquired := make(chan error, callers)
+	for idx := 0; idx < callers; idx++ {
+		go func() {
+			acquired <- lock.Acquire(2, time.Second)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	children, _, err := clients[0].backend.Children(lockPath)
+	assert.Equal(err, nil)
+	assert.Equal(len(children), 2)
+
+	assert.Equal(holder.Release(), nil)
+	for idx := 0; idx < callers; idx++ {
+		assert.Equal(<-acquired, nil)
+	}
+	assert.Equal(lock.HoldCount(), callers)
+
+	for idx := 0; idx < callers; idx++ {
+		assert.Equal(lock.Release(), nil)
+	}
+	assert.False(lock.IsLocked())
+
+	closeClients(clients)
+}
+
+// refusingBackend refuses to create queue nodes once refuse is set
+type refusingBackend struct {
+	*MemoryBackend
+	refuse int32
+}
+
+func (b *refusingBackend) CreateProtectedEphemeralSequential(path string, data []byte) (string, error) {
+	if atomic.LoadInt32(&b.refuse) == 1 {
+		return "", zk.ErrNoAuth
+	}
+	return b.MemoryBackend.CreateProtectedEphemeralSequential(path, data)
+}
+
+func (b *refusingBackend) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	if atomic.LoadInt32(&b.refuse) == 1 {
+		return nil, zk.ErrNoAuth
+	}
+	return b.MemoryBackend.Multi(ops...)
+}
+
+func TestMutexReEnqueueError(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/mutex/key09"
+
+	holderClient := newTestClient()
+	backend := &refusingBackend{MemoryBackend: testStore.NewBackend()}
+	waiterClient := NewClient(SetBackend(backend))
+	assert.Equal(waiterClient.Connect(), nil)
+
+	holder := NewMutex(holderClient, lockPath)
+	assert.Equal(holder.Acquire(1, time.Second), nil)
+
+	waiter := NewMutex(waiterClient, lockPath)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- waiter.AcquireContext(context.Background())
+	}()
+
+	var children []string
+	assert.Eventually(func() bool {
+		children, _, _ = holderClient.backend.Children(lockPath)
+		return len(children) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the waiter node is removed and a new one can't be created, the
+	// waiter finds it out when the holder releases the lock
+	atomic.StoreInt32(&backend.refuse, 1)
+	sort.Sort(ByNodeGUID(children))
+	assert.Equal(holderClient.backend.Delete(lockPath+"/"+children[1], -1), nil)
+	assert.Equal(holder.Release(), nil)
+
+	select {
+	case err := <-acquired:
+		assert.Equal(err, fmt.Errorf("%s - %s", zk.ErrNoAuth.Error(), lockPath))
+	case <-time.After(time.Second):
+		t.Fatal("Acquire kept waiting without a node")
+	}
+	assert.False(waiter.IsLocked())
+
+	holderClient.Disconnect()
+	waiterClient.Disconnect()
+}
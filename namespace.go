@@ -0,0 +1,151 @@
+package supervisor
+
+import (
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// namespaceBackend places every path under the cluster namespace and
+// strips it from the paths it returns, like a zookeeper chroot
+type namespaceBackend struct {
+	Backend
+	namespace string
+}
+
+func (b *namespaceBackend) Create(path string, data []byte, flags int32) (string, error) {
+	npath, err := b.Backend.Create(b.toBackend(path), data, flags)
+	return b.fromBackend(npath), err
+}
+
+func (b *namespaceBackend) CreateProtectedEphemeralSequential(path string, data []byte) (string, error) {
+	npath, err := b.Backend.CreateProtectedEphemeralSequential(b.toBackend(path), data)
+	return b.fromBackend(npath), err
+}
+
+func (b *namespaceBackend) Exists(path string) (bool, *zk.Stat, error) {
+	return b.Backend.Exists(b.toBackend(path))
+}
+
+func (b *namespaceBackend) ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	exists, stat, ch, err := b.Backend.ExistsW(b.toBackend(path))
+	return exists, stat, b.watch(ch), err
+}
+
+func (b *namespaceBackend) Get(path string) ([]byte, *zk.Stat, error) {
+	return b.Backend.Get(b.toBackend(path))
+}
+
+func (b *namespaceBackend) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	data, stat, ch, err := b.Backend.GetW(b.toBackend(path))
+	return data, stat, b.watch(ch), err
+}
+
+func (b *namespaceBackend) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	return b.Backend.Set(b.toBackend(path), data, version)
+}
+
+func (b *namespaceBackend) Delete(path string, version int32) error {
+	return b.Backend.Delete(b.toBackend(path), version)
+}
+
+func (b *namespaceBackend) Children(path string) ([]string, *zk.Stat, error) {
+	return b.Backend.Children(b.toBackend(path))
+}
+
+func (b *namespaceBackend) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	children, stat, ch, err := b.Backend.ChildrenW(b.toBackend(path))
+	return children, stat, b.watch(ch), err
+}
+
+func (b *namespaceBackend) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	nops := make([]interface{}, len(ops))
+	for idx, op := range ops {
+		switch op := op.(type) {
+		case *zk.CreateRequest:
+			nop := *op
+			nop.Path = b.toBackend(op.Path)
+			nops[idx] = &nop
+		case *zk.SetDataRequest:
+			nop := *op
+			nop.Path = b.toBackend(op.Path)
+			nops[idx] = &nop
+		case *zk.DeleteRequest:
+			nop := *op
+			nop.Path = b.toBackend(op.Path)
+			nops[idx] = &nop
+		case *zk.CheckVersionRequest:
+			nop := *op
+			nop.Path = b.toBackend(op.Path)
+			nops[idx] = &nop
+		default:
+			nops[idx] = op
+		}
+	}
+
+	responses, err := b.Backend.Multi(nops...)
+	for idx := range responses {
+		if responses[idx].String != "" {
+			responses[idx].String = b.fromBackend(responses[idx].String)
+		}
+	}
+	return responses, err
+}
+
+// toBackend prefixes absolute paths, invalid paths are kept so the
+// backend rejects them
+func (b *namespaceBackend) toBackend(path string) string {
+	if path == "" || path[0] != '/' {
+		return path
+	}
+	if path == "/" {
+		return b.namespace
+	}
+	return b.namespace + path
+}
+
+func (b *namespaceBackend) fromBackend(path string) string {
+	if path == b.namespace {
+		return "/"
+	}
+	if !strings.HasPrefix(path, b.namespace+"/") {
+		return path
+	}
+	return path[len(b.namespace):]
+}
+
+// watch strips namespace from event paths
+func (b *namespaceBackend) watch(ch <-chan zk.Event) <-chan zk.Event {
+	if ch == nil {
+		return nil
+	}
+
+	out := make(chan zk.Event, 1)
+	go func() {
+		defer close(out)
+		for event := range ch {
+			event.Path = b.fromBackend(event.Path)
+			out <- event
+		}
+	}()
+	return out
+}
+
+// createNamespace creates the namespace node, missing parents included
+func (b *namespaceBackend) createNamespace() error {
+	current := ""
+	for _, part := range strings.Split(b.namespace[1:], "/") {
+		current += "/" + part
+		if _, err := b.Backend.Create(current, []byte{}, 0); err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+func newNamespaceBackend(backend Backend, clusterName string) *namespaceBackend {
+	return &namespaceBackend{
+		Backend:   backend,
+		namespace: "/" + strings.Trim(clusterName, "/"),
+	}
+}
@@ -0,0 +1,69 @@
+package supervisor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceSeparatesClusters(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/namespace/key01"
+
+	client01 := NewClient(SetBackend(testStore.NewBackend()), SetClusterName("team01"))
+	client01.Connect()
+	client02 := NewClient(SetBackend(testStore.NewBackend()), SetClusterName("team02"))
+	client02.Connect()
+
+	lock01 := NewMutex(client01, lockPath)
+	lock02 := NewMutex(client02, lockPath)
+	assert.Nil(lock01.Acquire(1, time.Second))
+	assert.Nil(lock02.Acquire(1, time.Second))
+
+	// paths are returned without the namespace
+	assert.True(strings.HasPrefix(lock01.lockPath, lockPath+"/"))
+
+	raw := testStore.NewBackend()
+	raw.Connect(time.Second)
+	exists, _, _ := raw.Exists("/team01" + lock01.lockPath)
+	assert.True(exists)
+
+	assert.Nil(lock01.Release())
+	assert.Nil(lock02.Release())
+
+	raw.Close()
+	client01.Disconnect()
+	client02.Disconnect()
+}
+
+func TestNamespaceNotSetByDefault(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/namespace/key02"
+
+	client := newTestClient()
+	lock := NewMutex(client, lockPath)
+	assert.Nil(lock.Acquire(1, time.Second))
+
+	raw := testStore.NewBackend()
+	raw.Connect(time.Second)
+	exists, _, _ := raw.Exists(lock.lockPath)
+	assert.True(exists)
+
+	assert.Nil(lock.Release())
+	raw.Close()
+	client.Disconnect()
+}
+
+func TestNamespaceWrappedOnce(t *testing.T) {
+	assert := assert.New(t)
+	backend := testStore.NewBackend()
+
+	client := NewClient(SetBackend(backend), SetClusterName("team03"))
+	assert.Equal(client.backend, Backend(&namespaceBackend{Backend: backend, namespace: "/team03"}))
+
+	assert.Nil(client.Connect())
+	assert.Equal(client.backend, Backend(&namespaceBackend{Backend: backend, namespace: "/team03"}))
+	client.Disconnect()
+}
@@ -0,0 +1,51 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// PriorityQueue distributed queue taking items by priority, lower values
+// first and items with the same priority in the order they were put. The
+// priority is kept in the item name.
+type PriorityQueue struct {
+	queue *Queue
+}
+
+// Put adds data with priority
+func (pq *PriorityQueue) Put(ctx context.Context, data []byte, priority int64) error {
+	if !pq.queue.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return pq.queue.put(pq.queue.keyedName(pq.toKey(priority)), data)
+}
+
+// Take blocks until the item with the lowest priority is taken or ctx is
+// done
+func (pq *PriorityQueue) Take(ctx context.Context) (*QueueItem, error) {
+	return pq.queue.Take(ctx)
+}
+
+// Peek returns the data of the item with the lowest priority without
+// taking it
+func (pq *PriorityQueue) Peek() ([]byte, error) {
+	return pq.queue.Peek()
+}
+
+// toKey keeps priority order for negative values too
+func (pq *PriorityQueue) toKey(priority int64) uint64 {
+	return uint64(priority) ^ (math.MaxInt64 + 1)
+}
+
+// NewPriorityQueue returns new distributed priority queue
+func NewPriorityQueue(c *Client, path string, options ...QueueOptionsFunc) *PriorityQueue {
+	q := NewQueue(c, path, options...)
+	q.keyed = true
+	return &PriorityQueue{queue: q}
+}
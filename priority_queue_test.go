@@ -0,0 +1,38 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/queue/priority"
+
+	producer := NewPriorityQueue(clients[0], path)
+	consumer := NewPriorityQueue(clients[1], path, SetQueueAtLeastOnce())
+
+	assert.Equal(producer.Put(context.Background(), []byte("low01"), 10), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("high"), -5), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("low02"), 10), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("mid"), 0), nil)
+
+	data, err := consumer.Peek()
+	assert.Equal(err, nil)
+	assert.Equal(string(data), "high")
+
+	for _, expected := range []string{"high", "mid", "low01", "low02"} {
+		item, err := consumer.Take(context.Background())
+		assert.Equal(err, nil)
+		assert.Equal(string(item.Data), expected)
+		assert.Equal(item.Ack(), nil)
+	}
+
+	_, err = consumer.Peek()
+	assert.Equal(err, ErrQueueEmpty)
+
+	closeClients(clients)
+}
@@ -0,0 +1,376 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	queueItemsPath  = "items"
+	queueClaimsPath = "claims"
+	queueItemPrefix = "item-"
+)
+
+// ErrQueueEmpty returned by Peek when there are no items
+var ErrQueueEmpty = errors.New("Queue is empty")
+
+// Queue distributed FIFO queue. Items are persistent sequential nodes
+// taken in sequence order and removed when taken. In at-least-once mode an
+// item is claimed with an ephemeral node and only removed on Ack, so items
+// taken by a consumer whose session is gone are taken again by another one.
+type Queue struct {
+	client      *Client
+	ops         *clientOps
+	path        string
+	atLeastOnce bool
+
+	// keyed items carry a key before the sequence and are sorted by it,
+	// see ByNodeKey
+	keyed bool
+	// delayed items keep their due time in the key, they are not taken
+	// before it
+	delayed bool
+}
+
+// ByNodeKey order list of keyed items by key, then by incremental id
+type ByNodeKey []string
+
+func (nk ByNodeKey) getKey(name string) uint64 {
+	name = name[:strings.LastIndex(name, "-")]
+	key, _ := strconv.ParseUint(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return key
+}
+
+func (nk ByNodeKey) Len() int {
+	return len(nk)
+}
+
+func (nk ByNodeKey) Swap(i, j int) {
+	nk[i], nk[j] = nk[j], nk[i]
+}
+
+func (nk ByNodeKey) Less(i, j int) bool {
+	ki, kj := nk.getKey(nk[i]), nk.getKey(nk[j])
+	if ki != kj {
+		return ki < kj
+	}
+	return ByNodeGUID(nk).Less(i, j)
+}
+
+// QueueItem item taken from a queue
+type QueueItem struct {
+	queue *Queue
+	Data  []byte
+
+	path  string
+	claim string
+}
+
+// Put adds data at the end of the queue
+func (q *Queue) Put(ctx context.Context, data []byte) error {
+	if !q.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return q.put(queueItemPrefix, data)
+}
+
+// put creates the item with name followed by the sequence
+func (q *Queue) put(name string, data []byte) error {
+	if err := q.init(); err != nil {
+		return err
+	}
+
+	// not retried, a new attempt could add the item twice
+	itemsPath := q.path + "/" + queueItemsPath
+	if _, err := q.ops.backend.Create(itemsPath+"/"+name, data, zk.FlagSequence); err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), itemsPath)
+	}
+	return nil
+}
+
+// keyedName returns the name of an item with key
+func (q *Queue) keyedName(key uint64) string {
+	return fmt.Sprintf("%s%020d-", queueItemPrefix, key)
+}
+
+// Take blocks until an item is taken or ctx is done. In at-least-once mode
+// the item stays in the queue, claimed, until it's acknowledged.
+func (q *Queue) Take(ctx context.Context) (*QueueItem, error) {
+	if !q.client.isConnected() {
+		return nil, errors.New("Client not connected")
+	}
+
+	if err := q.init(); err != nil {
+		return nil, err
+	}
+
+	states, unsubscribe := q.client.subscribeConnectionState()
+	defer unsubscribe()
+
+	itemsPath := q.path + "/" + queueItemsPath
+	claimsPath := q.path + "/" + queueClaimsPath
+
+	for {
+		items, _, itemsChannel, err := q.ops.childrenWatch(itemsPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", err.Error(), itemsPath)
+		}
+
+		var claims []string
+		var claimsChannel <-chan zk.Event
+		if q.atLeastOnce {
+			// released claims make items available again
+			if claims, _, claimsChannel, err = q.ops.childrenWatch(claimsPath); err != nil {
+				return nil, fmt.Errorf("%s - %s", err.Error(), claimsPath)
+			}
+		}
+
+		available, wait := q.available(items, claims)
+		for _, name := range available {
+			item, err := q.take(name)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				return item, nil
+			}
+		}
+
+		// woken up when the earliest item not due yet is due
+		var due <-chan time.Time
+		var timer *time.Timer
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-itemsChannel:
+		case <-claimsChannel:
+		case <-due:
+		case <-states:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Peek returns the data of the first item without taking it, in
+// at-least-once mode claimed items are skipped and delayed items not due
+// yet are never returned
+func (q *Queue) Peek() ([]byte, error) {
+	itemsPath := q.path + "/" + queueItemsPath
+
+	items, err := q.ops.getSortedNodeGUIDList(itemsPath)
+	if err == zk.ErrNoNode {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", err.Error(), itemsPath)
+	}
+
+	var claims []string
+	if q.atLeastOnce {
+		if claims, err = q.ops.getSortedNodeGUIDList(q.path + "/" + queueClaimsPath); err != nil && err != zk.ErrNoNode {
+			return nil, err
+		}
+	}
+
+	available, _ := q.available(items, claims)
+	for _, name := range available {
+		data, _, err := q.ops.get(itemsPath + "/" + name)
+		if err == zk.ErrNoNode {
+			// taken meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", err.Error(), itemsPath)
+		}
+		return data, nil
+	}
+	return nil, ErrQueueEmpty
+}
+
+// available returns items not claimed in queue order. Delayed items not
+// due yet are left out, wait is the time until the earliest of them is due.
+func (q *Queue) available(items, claims []string) ([]string, time.Duration) {
+	claimed := make(map[string]bool, len(claims))
+	for _, claim := range claims {
+		claimed[claim] = true
+	}
+
+	available := make([]string, 0, len(items))
+	for _, item := range items {
+		if !claimed[item] {
+			available = append(available, item)
+		}
+	}
+
+	if !q.keyed {
+		sort.Sort(ByNodeGUID(available))
+		return available, 0
+	}
+
+	sort.Sort(ByNodeKey(available))
+	if !q.delayed {
+		return available, 0
+	}
+
+	now := time.Now()
+	for idx, name := range available {
+		if due := q.dueTime(name); due.After(now) {
+			return available[:idx], due.Sub(now)
+		}
+	}
+	return available, 0
+}
+
+// dueTime returns the time a delayed item is due
+func (q *Queue) dueTime(name string) time.Time {
+	key := ByNodeKey(nil).getKey(name)
+	return time.Unix(0, int64(key)*int64(time.Millisecond))
+}
+
+// take takes item name, nil when another consumer took it first
+func (q *Queue) take(name string) (*QueueItem, error) {
+	itemPath := q.path + "/" + queueItemsPath + "/" + name
+
+	if !q.atLeastOnce {
+		data, stat, err := q.ops.get(itemPath)
+		if err == zk.ErrNoNode {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", err.Error(), itemPath)
+		}
+
+		// only the consumer removing it takes it, not retried since a
+		// removal that went through would look like another consumer's
+		if err := q.ops.backend.Delete(itemPath, stat.Version); err != nil {
+			if err == zk.ErrNoNode {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%s - %s", err.Error(), itemPath)
+		}
+		return &QueueItem{queue: q, Data: data, path: itemPath}, nil
+	}
+
+	claimPath := q.path + "/" + queueClaimsPath + "/" + name
+	if _, err := q.ops.backend.Create(claimPath, []byte{}, zk.FlagEphemeral); err != nil {
+		if err == zk.ErrNodeExists {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s - %s", err.Error(), claimPath)
+	}
+
+	data, _, err := q.ops.get(itemPath)
+	if err != nil {
+		q.ops.deleteNodeLastVersion(claimPath)
+		if err == zk.ErrNoNode {
+			// acknowledged by a consumer that claimed it before us
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s - %s", err.Error(), itemPath)
+	}
+	return &QueueItem{queue: q, Data: data, path: itemPath, claim: claimPath}, nil
+}
+
+// init creates items and claims nodes unless another participant already
+// did it
+func (q *Queue) init() error {
+	if _, err := q.ops.createParentNodeIfNotExists(q.path+"/"+queueItemsPath, []byte{}); err != nil {
+		return err
+	}
+	if q.atLeastOnce {
+		if _, err := q.ops.createParentNodeIfNotExists(q.path+"/"+queueClaimsPath, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ack removes the item and its claim together. Items taken without
+// at-least-once mode are already removed.
+func (i *QueueItem) Ack() error {
+	if i.claim == "" {
+		return nil
+	}
+
+	_, err := i.queue.ops.txn().
+		Delete(i.path, -1).
+		Delete(i.claim, -1).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("Could not acknowledge %s - %s", i.path, err.Error())
+	}
+
+	i.claim = ""
+	return nil
+}
+
+// Release gives the item back to the queue without removing it, so
+// another consumer can take it
+func (i *QueueItem) Release() error {
+	if i.claim == "" {
+		return errors.New("Queue item not claimed")
+	}
+
+	if err := i.queue.ops.deleteNodeLastVersion(i.claim); err != nil {
+		return fmt.Errorf("Could not release %s - %s", i.path, err.Error())
+	}
+
+	i.claim = ""
+	return nil
+}
+
+// QueueOptionsFunc queue definition
+type QueueOptionsFunc func(*Queue)
+
+// SetQueueAtLeastOnce makes taken items stay in the queue until they are
+// acknowledged, items claimed by a consumer whose session is gone are
+// taken again
+func SetQueueAtLeastOnce() QueueOptionsFunc {
+	return func(q *Queue) {
+		q.atLeastOnce = true
+	}
+}
+
+// SetQueueRetryPolicy overrides client retry policy for this queue
+func SetQueueRetryPolicy(policy RetryPolicy) QueueOptionsFunc {
+	return func(q *Queue) {
+		q.ops.policy = policy
+	}
+}
+
+// NewQueue returns new distributed FIFO queue
+func NewQueue(c *Client, path string, options ...QueueOptionsFunc) *Queue {
+	q := Queue{
+		client: c,
+		ops:    c.ops(nil),
+		path:   path,
+	}
+
+	for _, option := range options {
+		option(&q)
+	}
+
+	return &q
+}
@@ -0,0 +1,102 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueFIFO(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/queue/fifo"
+
+	producer := NewQueue(clients[0], path)
+	consumer := NewQueue(clients[1], path)
+
+	_, err := consumer.Peek()
+	assert.Equal(err, ErrQueueEmpty)
+
+	for idx := 0; idx < 12; idx++ {
+		assert.Equal(producer.Put(context.Background(), []byte(fmt.Sprintf("job%02d", idx))), nil)
+	}
+
+	data, err := consumer.Peek()
+	assert.Equal(err, nil)
+	assert.Equal(string(data), "job00")
+
+	for idx := 0; idx < 12; idx++ {
+		item, err := consumer.Take(context.Background())
+		assert.Equal(err, nil)
+		assert.Equal(string(item.Data), fmt.Sprintf("job%02d", idx))
+		assert.Equal(item.Ack(), nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err = consumer.Take(ctx)
+	assert.Equal(err, context.DeadlineExceeded)
+	cancel()
+
+	// take blocks until an item is put
+	taken := make(chan *QueueItem, 1)
+	go func() {
+		item, _ := consumer.Take(context.Background())
+		taken <- item
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(producer.Put(context.Background(), []byte("late")), nil)
+	assert.Equal(string((<-taken).Data), "late")
+
+	closeClients(clients)
+}
+
+func TestQueueAtLeastOnce(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(3)
+	path := "/supervisor/test/queue/atleastonce"
+
+	producer := NewQueue(clients[0], path, SetQueueAtLeastOnce())
+	consumer01 := NewQueue(clients[1], path, SetQueueAtLeastOnce())
+	consumer02 := NewQueue(clients[2], path, SetQueueAtLeastOnce())
+
+	assert.Equal(producer.Put(context.Background(), []byte("job01")), nil)
+	assert.Equal(producer.Put(context.Background(), []byte("job02")), nil)
+
+	item01, err := consumer01.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item01.Data), "job01")
+
+	// claimed items are skipped
+	item02, err := consumer02.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item02.Data), "job02")
+
+	// released items are taken again
+	assert.Equal(item02.Release(), nil)
+	item02, err = consumer02.Take(context.Background())
+	assert.Equal(err, nil)
+	assert.Equal(string(item02.Data), "job02")
+	assert.Equal(item02.Ack(), nil)
+
+	// consumer01 crashes, its claim goes away with the session
+	waiting := make(chan *QueueItem, 1)
+	go func() {
+		item, _ := consumer02.Take(context.Background())
+		waiting <- item
+	}()
+	time.Sleep(20 * time.Millisecond)
+	clients[1].Disconnect()
+
+	item01 = <-waiting
+	assert.Equal(string(item01.Data), "job01")
+	assert.Equal(item01.Ack(), nil)
+
+	_, err = producer.Peek()
+	assert.Equal(err, ErrQueueEmpty)
+
+	clients[0].Disconnect()
+	clients[2].Disconnect()
+}
@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// defaultRetryPolicy used when the client has no retry policy set
+var defaultRetryPolicy = NewExponentialBackoffRetry(100*time.Millisecond, 2*time.Second, 3)
+
+// RetryPolicy decides if a failed zookeeper call is tried again
+type RetryPolicy interface {
+	// AllowRetry is called after the retries failure, starting at 1, with
+	// the time elapsed since the first attempt. It returns how long to
+	// sleep before trying again, false gives up.
+	AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool)
+}
+
+// SetRetryPolicy sets retry policy used by every recipe unless the recipe
+// overrides it
+func SetRetryPolicy(policy RetryPolicy) NodeOpionsFunc {
+	return func(c *Client) error {
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+type exponentialBackoffRetry struct {
+	baseSleep  time.Duration
+	maxSleep   time.Duration
+	maxRetries int
+}
+
+// NewExponentialBackoffRetry retries up to maxRetries times, sleep starts
+// at baseSleep and doubles each retry up to maxSleep. Each sleep is
+// randomized between half and the full value, so clients failing at the
+// same time don't retry at the same time.
+func NewExponentialBackoffRetry(baseSleep, maxSleep time.Duration, maxRetries int) RetryPolicy {
+	return &exponentialBackoffRetry{
+		baseSleep:  baseSleep,
+		maxSleep:   maxSleep,
+		maxRetries: maxRetries,
+	}
+}
+
+func (p *exponentialBackoffRetry) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	if retries > p.maxRetries {
+		return 0, false
+	}
+
+	sleep := p.maxSleep
+	if shift := uint(retries - 1); shift < 32 && p.baseSleep<<shift < p.maxSleep {
+		sleep = p.baseSleep << shift
+	}
+
+	if half := int64(sleep / 2); half > 0 {
+		sleep = time.Duration(half + rand.Int63n(half+1))
+	}
+	return sleep, true
+}
+
+type boundedTimeRetry struct {
+	maxElapsed time.Duration
+	sleep      time.Duration
+}
+
+// NewBoundedTimeRetry retries every sleep until maxElapsed has passed
+// since the first attempt
+func NewBoundedTimeRetry(maxElapsed, sleep time.Duration) RetryPolicy {
+	return &boundedTimeRetry{maxElapsed: maxElapsed, sleep: sleep}
+}
+
+func (p *boundedTimeRetry) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	if elapsed >= p.maxElapsed {
+		return 0, false
+	}
+	if remaining := p.maxElapsed - elapsed; remaining < p.sleep {
+		return remaining, true
+	}
+	return p.sleep, true
+}
+
+type fixedRetry struct {
+	n     int
+	sleep time.Duration
+}
+
+// NewFixedRetry retries n times, sleeping the same time between retries.
+// NewFixedRetry(0, 0) never retries.
+func NewFixedRetry(n int, sleep time.Duration) RetryPolicy {
+	return &fixedRetry{n: n, sleep: sleep}
+}
+
+func (p *fixedRetry) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	return p.sleep, retries <= p.n
+}
+
+type foreverRetry struct {
+	sleep time.Duration
+}
+
+// NewForeverRetry retries until the call succeeds or the client disconnects
+func NewForeverRetry(sleep time.Duration) RetryPolicy {
+	return &foreverRetry{sleep: sleep}
+}
+
+func (p *foreverRetry) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	return p.sleep, true
+}
+
+// isRetryable returns true for errors caused by the connection, the
+// same call may succeed once the client reconnects
+func isRetryable(err error) bool {
+	return err == zk.ErrConnectionClosed || err == zk.ErrNoServer
+}
@@ -0,0 +1,74 @@
+package supervisor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	exponential := NewExponentialBackoffRetry(100*time.Millisecond, 300*time.Millisecond, 3)
+	for retries, max := range []time.Duration{100, 200, 300} {
+		sleep, ok := exponential.AllowRetry(retries+1, 0)
+		assert.True(ok)
+		assert.True(sleep >= max*time.Millisecond/2 && sleep <= max*time.Millisecond)
+	}
+	_, ok := exponential.AllowRetry(4, 0)
+	assert.False(ok)
+
+	bounded := NewBoundedTimeRetry(time.Second, 300*time.Millisecond)
+	sleep, ok := bounded.AllowRetry(1, 900*time.Millisecond)
+	assert.True(ok)
+	assert.Equal(sleep, 100*time.Millisecond)
+	_, ok = bounded.AllowRetry(2, time.Second)
+	assert.False(ok)
+
+	fixed := NewFixedRetry(2, time.Millisecond)
+	_, ok = fixed.AllowRetry(2, 0)
+	assert.True(ok)
+	_, ok = fixed.AllowRetry(3, 0)
+	assert.False(ok)
+
+	forever := NewForeverRetry(time.Millisecond)
+	_, ok = forever.AllowRetry(1000, time.Hour)
+	assert.True(ok)
+}
+
+func TestRetryConnectionErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := testStore.NewBackend()
+	client := NewClient(
+		SetBackend(backend),
+		SetRetryPolicy(NewForeverRetry(10*time.Millisecond)),
+	)
+	assert.Equal(client.Connect(), nil)
+
+	// recipe override gives up right away
+	lock := NewMutex(client, "/supervisor/test/retry/mutex", SetMutexRetryPolicy(NewFixedRetry(0, 0)))
+
+	backend.Suspend()
+	err := lock.enqueue()
+	assert.Equal(err, fmt.Errorf("%s - %s", zk.ErrConnectionClosed.Error(), "/supervisor/test/retry/mutex"))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		backend.Resume()
+	}()
+
+	// client policy keeps trying until the connection is back
+	_, err = client.ops(nil).createParentNodeIfNotExists("/supervisor/test/retry/node", []byte{})
+	assert.Equal(err, nil)
+
+	exists, _, err := backend.Exists("/supervisor/test/retry/node")
+	assert.Equal(err, nil)
+	assert.True(exists)
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion("/supervisor/test/retry/node"), nil)
+	client.Disconnect()
+}
@@ -0,0 +1,378 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	rwMutexReadName  = "read-"
+	rwMutexWriteName = "write-"
+)
+
+// RWMutex holds read-write distributed lock information. Readers share
+// the lock, writers are exclusive. Readers and writers are queued in the
+// same sequence, so writers are served in order and a reader only waits
+// for the nearest writer ahead of it.
+type RWMutex struct {
+	client *Client
+	ops    *clientOps
+	path   string
+
+	mu          sync.Mutex
+	read        rwMutexHold
+	write       rwMutexHold
+	lost        chan struct{}
+	unsubscribe func()
+}
+
+// rwMutexHold read or write side of a RWMutex
+type rwMutexHold struct {
+	name      string
+	lockPath  string
+	acquiring bool
+}
+
+// RLock blocks until read lock is acquired
+func (rw *RWMutex) RLock() error {
+	return rw.RLockContext(context.Background())
+}
+
+// RLockContext blocks until read lock is acquired or ctx is done
+func (rw *RWMutex) RLockContext(ctx context.Context) error {
+	return rw.lock(ctx, &rw.read, "Key ["+rw.path+"] already read locked")
+}
+
+// RUnlock releases read lock
+func (rw *RWMutex) RUnlock() error {
+	return rw.unlock(&rw.read, "Key ["+rw.path+"] not read locked")
+}
+
+// Lock blocks until write lock is acquired
+func (rw *RWMutex) Lock() error {
+	return rw.LockContext(context.Background())
+}
+
+// LockContext blocks until write lock is acquired or ctx is done
+func (rw *RWMutex) LockContext(ctx context.Context) error {
+	return rw.lock(ctx, &rw.write, "Key ["+rw.path+"] already locked")
+}
+
+// Unlock releases write lock
+func (rw *RWMutex) Unlock() error {
+	return rw.unlock(&rw.write, "Key ["+rw.path+"] not locked")
+}
+
+// IsRLocked returns true while this mutex holds the read lock
+func (rw *RWMutex) IsRLocked() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.read.lockPath != ""
+}
+
+// IsLocked returns true while this mutex holds the write lock
+func (rw *RWMutex) IsLocked() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.write.lockPath != ""
+}
+
+// Lost returns a channel closed when the read and write locks held are
+// lost because of the connection state. It's closed too when neither of
+// them is held anymore after RUnlock or Unlock.
+func (rw *RWMutex) Lost() <-chan struct{} {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.lost
+}
+
+func (rw *RWMutex) lock(ctx context.Context, hold *rwMutexHold, locked string) error {
+	rw.mu.Lock()
+	if hold.lockPath != "" || hold.acquiring {
+		rw.mu.Unlock()
+		return errors.New(locked)
+	}
+	hold.acquiring = true
+	rw.mu.Unlock()
+
+	states, unsubscribe := rw.client.subscribeConnectionState()
+	lockPath, err := rw.acquire(ctx, states, hold.name)
+
+	rw.mu.Lock()
+	hold.acquiring = false
+	if err != nil {
+		rw.mu.Unlock()
+		unsubscribe()
+		return err
+	}
+	hold.lockPath = lockPath
+	if rw.unsubscribe != nil {
+		// already watched for the other lock held
+		rw.mu.Unlock()
+		unsubscribe()
+		return nil
+	}
+	rw.lost = make(chan struct{})
+	rw.unsubscribe = unsubscribe
+	lost := rw.lost
+	rw.mu.Unlock()
+
+	go rw.watchConnection(states, lost)
+	return nil
+}
+
+func (rw *RWMutex) unlock(hold *rwMutexHold, notLocked string) error {
+	rw.mu.Lock()
+	lockPath := hold.lockPath
+	rw.mu.Unlock()
+
+	if lockPath == "" {
+		return errors.New(notLocked)
+	}
+
+	if err := rw.release(lockPath); err != nil {
+		return err
+	}
+
+	rw.mu.Lock()
+	hold.lockPath = ""
+	var unsubscribe func()
+	if rw.read.lockPath == "" && rw.write.lockPath == "" && rw.unsubscribe != nil {
+		close(rw.lost)
+		unsubscribe = rw.unsubscribe
+		rw.unsubscribe = nil
+	}
+	rw.mu.Unlock()
+
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+	return nil
+}
+
+// watchConnection marks the locks as not held when the session holding
+// their nodes is lost or expires
+func (rw *RWMutex) watchConnection(states <-chan ConnectionState, lost chan struct{}) {
+	for {
+		select {
+		case state := <-states:
+			if state != ConnectionStateLost && state != ConnectionStateExpired {
+				continue
+			}
+
+			rw.mu.Lock()
+			if rw.lost != lost || rw.unsubscribe == nil {
+				rw.mu.Unlock()
+				return
+			}
+			rw.client.logger.Errorf("Lock %s lost: connection %s", rw.path, state)
+			var lockPaths []string
+			for _, hold := range []*rwMutexHold{&rw.read, &rw.write} {
+				if hold.lockPath != "" {
+					lockPaths = append(lockPaths, hold.lockPath)
+					hold.lockPath = ""
+				}
+			}
+			close(lost)
+			unsubscribe := rw.unsubscribe
+			rw.unsubscribe = nil
+			rw.mu.Unlock()
+
+			if state == ConnectionStateLost {
+				rw.removeLostNodes(states, lockPaths)
+			}
+			unsubscribe()
+			return
+		case <-lost:
+			return
+		}
+	}
+}
+
+// removeLostNodes removes the nodes of lost locks when the connection
+// comes back with the same session, they would block every waiter
+// otherwise. When the session expires the nodes are already gone.
+func (rw *RWMutex) removeLostNodes(states <-chan ConnectionState, lockPaths []string) {
+	for {
+		select {
+		case state := <-states:
+			switch state {
+			case ConnectionStateReconnected:
+				for _, lockPath := range lockPaths {
+					rw.remove(lockPath)
+				}
+				return
+			case ConnectionStateExpired, ConnectionStateConnected:
+				return
+			}
+		case <-rw.ops.done:
+			return
+		}
+	}
+}
+
+// acquire queues a node named name and waits until nothing blocks it.
+// Returns the node path.
+func (rw *RWMutex) acquire(ctx context.Context, states <-chan ConnectionState, name string) (string, error) {
+	if !rw.client.isConnected() {
+		return "", errors.New("Client not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	lockPath, guid, err := rw.enqueue(name)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		var channel <-chan zk.Event
+
+		if lockPath != "" {
+			children, err := rw.ops.getSortedNodeGUIDList(rw.path)
+			if err == zk.ErrNoNode {
+				// the lock node went away with ours
+				children, err = nil, nil
+			}
+			if err != nil {
+				rw.remove(lockPath)
+				return "", fmt.Errorf("%s - %s", err.Error(), rw.path)
+			}
+
+			blocker, queued := rw.blocker(children, guid, name)
+
+			switch {
+			case !queued:
+				// our node is gone, take a new place in the queue
+				lockPath, guid = "", ""
+				if rw.client.isConnected() {
+					if lockPath, guid, err = rw.enqueue(name); err != nil {
+						return "", err
+					}
+					continue
+				}
+			case blocker == "":
+				return lockPath, nil
+			default:
+				exists, _, ch, err := rw.ops.existsWatch(rw.path + "/" + blocker)
+				if err != nil {
+					rw.remove(lockPath)
+					return "", fmt.Errorf("%s - %s", err.Error(), rw.path)
+				}
+				if !exists {
+					continue
+				}
+				channel = ch
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			rw.remove(lockPath)
+			return "", ctx.Err()
+		case <-channel:
+		case state := <-states:
+			switch state {
+			case ConnectionStateExpired:
+				if !rw.ops.ownsNode(lockPath) {
+					lockPath, guid = "", ""
+				}
+			case ConnectionStateConnected:
+				if lockPath == "" {
+					if lockPath, guid, err = rw.enqueue(name); err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+	}
+}
+
+// blocker returns the node we have to wait for. Writers wait for the
+// node right ahead of them, readers for the nearest writer ahead.
+func (rw *RWMutex) blocker(children []string, guid, name string) (string, bool) {
+	for idx, child := range children {
+		if child != guid {
+			continue
+		}
+
+		if name == rwMutexWriteName {
+			if idx == 0 {
+				return "", true
+			}
+			return children[idx-1], true
+		}
+
+		for prev := idx - 1; prev >= 0; prev-- {
+			if strings.Contains(children[prev], "-"+rwMutexWriteName) {
+				return children[prev], true
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
+
+func (rw *RWMutex) enqueue(name string) (string, string, error) {
+	abspath, guid, err := rw.ops.createProtectedEphemeralSequentialNamed(rw.path, name, []byte{})
+	if err != nil {
+		return "", "", fmt.Errorf("%s - %s", err.Error(), rw.path)
+	}
+	return abspath, guid, nil
+}
+
+// remove is used when giving up, errors are only logged
+func (rw *RWMutex) remove(lockPath string) {
+	if lockPath == "" {
+		return
+	}
+	if err := rw.ops.deleteNodeLastVersion(lockPath); err != nil {
+		rw.client.logger.Errorf("Could not remove node %s - %s", lockPath, err.Error())
+	}
+}
+
+func (rw *RWMutex) release(lockPath string) error {
+	if err := rw.ops.deleteNodeLastVersion(lockPath); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", lockPath, err.Error())
+	}
+
+	// other clients may be queued on the same path
+	if err := rw.ops.deleteNodeLastVersion(rw.path); err != nil && err != zk.ErrNotEmpty {
+		return err
+	}
+	return nil
+}
+
+// RWMutexOptionsFunc read-write mutex definition
+type RWMutexOptionsFunc func(*RWMutex)
+
+// SetRWMutexRetryPolicy overrides client retry policy for this read-write mutex
+func SetRWMutexRetryPolicy(policy RetryPolicy) RWMutexOptionsFunc {
+	return func(rw *RWMutex) {
+		rw.ops.policy = policy
+	}
+}
+
+// NewRWMutex returns new read-write mutex for distributed lock
+func NewRWMutex(c *Client, path string, options ...RWMutexOptionsFunc) *RWMutex {
+	rw := RWMutex{
+		client: c,
+		ops:    c.ops(nil),
+		path:   path,
+		read:   rwMutexHold{name: rwMutexReadName},
+		write:  rwMutexHold{name: rwMutexWriteName},
+	}
+
+	for _, option := range options {
+		option(&rw)
+	}
+
+	return &rw
+}
@@ -0,0 +1,169 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRWMutexReadersShare(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(3)
+	lockPath := "/supervisor/test/rwmutex/key01"
+
+	reader01 := NewRWMutex(clients[0], lockPath)
+	reader02 := NewRWMutex(clients[1], lockPath)
+	writer := NewRWMutex(clients[2], lockPath)
+
+	assert.Nil(reader01.RLock())
+	assert.Nil(reader02.RLock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	assert.Equal(writer.LockContext(ctx), context.DeadlineExceeded)
+	cancel()
+
+	assert.Nil(reader01.RUnlock())
+	assert.Nil(reader02.RUnlock())
+	assert.Nil(writer.Lock())
+	assert.Nil(writer.Unlock())
+
+	closeClients(clients)
+}
+
+func TestRWMutexReaderWaitsForWriter(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/rwmutex/key02"
+
+	writer := NewRWMutex(clients[0], lockPath)
+	reader := NewRWMutex(clients[1], lockPath)
+
+	assert.Nil(writer.Lock())
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- reader.RLock()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("reader acquired while writer holds the lock")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	assert.Nil(writer.Unlock())
+	assert.Nil(<-acquired)
+	assert.Nil(reader.RUnlock())
+
+	closeClients(clients)
+}
+
+func TestRWMutexLostOnExpire(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/rwmutex/key03"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Nil(client.Connect())
+	other := newTestClient()
+
+	rw := NewRWMutex(client, lockPath)
+	assert.Nil(rw.RLock())
+	assert.True(rw.IsRLocked())
+
+	lost := rw.Lost()
+	backend.Expire()
+	<-lost
+	assert.False(rw.IsRLocked())
+	assert.NotNil(rw.RUnlock())
+
+	writer := NewRWMutex(other, lockPath)
+	assert.Nil(writer.Lock())
+	assert.Nil(writer.Unlock())
+
+	// closed when nothing is held anymore
+	assert.Nil(rw.RLock())
+	lost = rw.Lost()
+	assert.Nil(rw.RUnlock())
+	<-lost
+
+	client.Disconnect()
+	other.Disconnect()
+}
+
+func TestRWMutexConcurrentLock(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	lockPath := "/supervisor/test/rwmutex/key04"
+
+	holder := NewRWMutex(clients[0], lockPath)
+	assert.Nil(holder.Lock())
+
+	rw := NewRWMutex(clients[1], lockPath)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- rw.Lock()
+	}()
+
+	// the same mutex can't queue a second write node
+	assert.Eventually(func() bool {
+		children, _, _ := clients[0].backend.Children(lockPath)
+		return len(children) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.EqualError(rw.Lock(), "Key ["+lockPath+"] already locked")
+
+	assert.Nil(holder.Unlock())
+	assert.Nil(<-acquired)
+	assert.Nil(rw.Unlock())
+
+	closeClients(clients)
+}
+
+func TestRWMutexReEnqueueError(t *testing.T) {
+	assert := assert.New(t)
+	lockPath := "/supervisor/test/rwmutex/key05"
+
+	holderClient := newTestClient()
+	backend := &refusingBackend{MemoryBackend: testStore.NewBackend()}
+	waiterClient := NewClient(SetBackend(backend))
+	assert.Nil(waiterClient.Connect())
+
+	holder := NewRWMutex(holderClient, lockPath)
+	assert.Nil(holder.Lock())
+
+	waiter := NewRWMutex(waiterClient, lockPath)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- waiter.RLock()
+	}()
+
+	var children []string
+	assert.Eventually(func() bool {
+		children, _, _ = holderClient.backend.Children(lockPath)
+		return len(children) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the waiter node is removed and a new one can't be created, the
+	// waiter finds it out when the holder releases the lock
+	atomic.StoreInt32(&backend.refuse, 1)
+	sort.Sort(ByNodeGUID(children))
+	assert.Nil(holderClient.backend.Delete(lockPath+"/"+children[1], -1))
+	assert.Nil(holder.Unlock())
+
+	select {
+	case err := <-acquired:
+		assert.Equal(err, fmt.Errorf("%s - %s", zk.ErrNoAuth.Error(), lockPath))
+	case <-time.After(time.Second):
+		t.Fatal("RLock kept waiting without a node")
+	}
+	assert.False(waiter.IsRLocked())
+
+	holderClient.Disconnect()
+	waiterClient.Disconnect()
+}
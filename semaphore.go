@@ -0,0 +1,255 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	semaphoreLeasesPath = "leases"
+	semaphoreLocksPath  = "locks"
+	semaphoreMaxPath    = "max"
+)
+
+// Semaphore holds counting semaphore information. At most max leases are
+// held at the same time across every participant. Max leases are kept in
+// a shared node, so every participant agrees on the value.
+type Semaphore struct {
+	client    *Client
+	ops       *clientOps
+	path      string
+	maxLeases *AtomicUint64
+	initial   uint64
+}
+
+// Lease lease acquired from a semaphore, it must be closed to return it
+type Lease struct {
+	semaphore *Semaphore
+	path      string
+}
+
+// Acquire blocks until n leases are acquired or ctx is done. When ctx
+// is done every node already created is removed.
+func (s *Semaphore) Acquire(ctx context.Context, n int) ([]*Lease, error) {
+	if !s.client.isConnected() {
+		return nil, errors.New("Client not connected")
+	}
+
+	if n <= 0 {
+		return nil, fmt.Errorf("Invalid number of leases %d", n)
+	}
+
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+
+	states, unsubscribe := s.client.subscribeConnectionState()
+	defer unsubscribe()
+
+	leases, err := s.enqueue(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+
+	leasesPath := s.path + "/" + semaphoreLeasesPath
+	maxPath := s.path + "/" + semaphoreMaxPath
+
+	for {
+		var childrenChannel, maxChannel <-chan zk.Event
+
+		if leases != nil {
+			data, _, mch, err := s.ops.getWatch(maxPath)
+			if err != nil {
+				s.closeLeases(leases)
+				return nil, err
+			}
+
+			children, _, cch, err := s.ops.childrenWatch(leasesPath)
+			if err != nil {
+				s.closeLeases(leases)
+				return nil, err
+			}
+
+			max := int(s.maxLeases.fromBytes(data))
+			acquired, queued := s.acquired(children, leases, max)
+
+			switch {
+			case !queued:
+				// some lease node is gone, queue every lease again
+				s.closeLeases(leases)
+				leases = nil
+				if s.client.isConnected() {
+					if leases, err = s.enqueue(ctx, n); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			case acquired:
+				return leases, nil
+			case n > max:
+				s.closeLeases(leases)
+				return nil, fmt.Errorf("Can't acquire %d leases, max is %d", n, max)
+			}
+
+			childrenChannel = cch
+			maxChannel = mch
+		}
+
+		select {
+		case <-ctx.Done():
+			s.closeLeases(leases)
+			return nil, ctx.Err()
+		case <-childrenChannel:
+		case <-maxChannel:
+		case state := <-states:
+			if state == ConnectionStateConnected && leases == nil {
+				if leases, err = s.enqueue(ctx, n); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// MaxLeases returns the shared max leases
+func (s *Semaphore) MaxLeases() (int, error) {
+	if err := s.init(); err != nil {
+		return 0, err
+	}
+
+	max, err := s.maxLeases.Get()
+	if err != nil {
+		return 0, err
+	}
+	return int(max), nil
+}
+
+// SetMaxLeases changes the shared max leases for every participant
+func (s *Semaphore) SetMaxLeases(max int) error {
+	if max <= 0 {
+		return fmt.Errorf("Invalid max leases %d", max)
+	}
+	_, err := s.maxLeases.TrySet(uint64(max))
+	return err
+}
+
+// init creates max leases node with initial value unless another
+// participant already did it
+func (s *Semaphore) init() error {
+	_, err := s.ops.createParentNodeIfNotExists(s.path+"/"+semaphoreMaxPath, s.maxLeases.toBytes(s.initial))
+	return err
+}
+
+// enqueue creates n lease nodes. Internal lock keeps them next to each
+// other in the queue, so two participants never hold part of the leases
+// each other is waiting for.
+func (s *Semaphore) enqueue(ctx context.Context, n int) ([]*Lease, error) {
+	lock := NewMutex(s.client, s.path+"/"+semaphoreLocksPath, SetMutexRetryPolicy(s.ops.policy))
+	if err := lock.AcquireContext(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			s.client.logger.Errorf("%s", err.Error())
+		}
+	}()
+
+	leasesPath := s.path + "/" + semaphoreLeasesPath
+	leases := make([]*Lease, 0, n)
+	for idx := 0; idx < n; idx++ {
+		abspath, _, err := s.ops.createProtectedEphemeralSequential(leasesPath, []byte{})
+		if err != nil {
+			s.closeLeases(leases)
+			return nil, fmt.Errorf("%s - %s", err.Error(), leasesPath)
+		}
+		leases = append(leases, &Lease{semaphore: s, path: abspath})
+	}
+	return leases, nil
+}
+
+// acquired returns true when every lease is among the first max nodes,
+// queued is false when some lease node is missing
+func (s *Semaphore) acquired(children []string, leases []*Lease, max int) (bool, bool) {
+	sort.Sort(ByNodeGUID(children))
+
+	position := make(map[string]int, len(children))
+	for idx, child := range children {
+		position[s.path+"/"+semaphoreLeasesPath+"/"+child] = idx
+	}
+
+	acquired := true
+	for _, lease := range leases {
+		idx, ok := position[lease.path]
+		if !ok {
+			return false, false
+		}
+		if idx >= max {
+			acquired = false
+		}
+	}
+	return acquired, true
+}
+
+func (s *Semaphore) closeLeases(leases []*Lease) {
+	for _, lease := range leases {
+		if err := lease.Close(); err != nil {
+			s.client.logger.Errorf("%s", err.Error())
+		}
+	}
+}
+
+// Close returns the lease to the semaphore
+func (l *Lease) Close() error {
+	if l.path == "" {
+		return errors.New("Lease already closed")
+	}
+
+	if err := l.semaphore.ops.deleteNodeLastVersion(l.path); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", l.path, err.Error())
+	}
+
+	l.path = ""
+	return nil
+}
+
+// Path returns lease node path
+func (l *Lease) Path() string {
+	return l.path
+}
+
+// SemaphoreOptionsFunc semaphore definition
+type SemaphoreOptionsFunc func(*Semaphore)
+
+// SetSemaphoreRetryPolicy overrides client retry policy for this semaphore
+func SetSemaphoreRetryPolicy(policy RetryPolicy) SemaphoreOptionsFunc {
+	return func(s *Semaphore) {
+		s.ops.policy = policy
+	}
+}
+
+// NewSemaphore returns new semaphore allowing maxLeases leases, when the
+// shared max leases node already exists its value is used instead.
+// maxLeases lower than 1 is taken as 1.
+func NewSemaphore(c *Client, path string, maxLeases int, options ...SemaphoreOptionsFunc) *Semaphore {
+	if maxLeases <= 0 {
+		maxLeases = 1
+	}
+
+	s := Semaphore{
+		client:  c,
+		ops:     c.ops(nil),
+		path:    path,
+		initial: uint64(maxLeases),
+	}
+
+	for _, option := range options {
+		option(&s)
+	}
+
+	s.maxLeases = NewAtomicUint64(c, path+"/"+semaphoreMaxPath, SetAtomicRetryPolicy(s.ops.policy))
+	return &s
+}
@@ -0,0 +1,83 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphoreMaxLeases(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/semaphore/api01"
+
+	// max leases is kept by the store, a fresh one sets it again every run
+	store := NewMemoryStore()
+	clients := make([]*Client, 3)
+	for idx := range clients {
+		clients[idx] = NewClient(SetBackend(store.NewBackend()))
+		clients[idx].Connect()
+	}
+
+	semaphore01 := NewSemaphore(clients[0], path, 2)
+	semaphore02 := NewSemaphore(clients[1], path, 10)
+
+	leases01, err := semaphore01.Acquire(context.Background(), 2)
+	assert.Nil(err)
+	assert.Len(leases01, 2)
+
+	// first participant defines max leases
+	max, _ := semaphore02.MaxLeases()
+	assert.Equal(max, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err = semaphore02.Acquire(ctx, 1)
+	assert.Equal(err, context.DeadlineExceeded)
+	cancel()
+
+	assert.Nil(leases01[0].Close())
+	leases02, err := semaphore02.Acquire(context.Background(), 1)
+	assert.Nil(err)
+
+	semaphore03 := NewSemaphore(clients[2], path, 2)
+	assert.Nil(semaphore03.SetMaxLeases(3))
+	leases03, err := semaphore03.Acquire(context.Background(), 1)
+	assert.Nil(err)
+
+	assert.Nil(leases01[1].Close())
+	assert.Nil(leases02[0].Close())
+	assert.Nil(leases03[0].Close())
+	assert.NotNil(leases03[0].Close())
+
+	ops := clients[0].ops(nil)
+	for _, node := range []string{semaphoreLeasesPath, semaphoreLocksPath, semaphoreMaxPath} {
+		assert.Nil(ops.deleteBaseNode(path + "/" + node))
+	}
+	exists, _, _ := clients[0].backend.Exists(path)
+	assert.False(exists)
+
+	closeClients(clients)
+}
+
+func TestSemaphoreInvalidMaxLeases(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/semaphore/api02"
+	client := NewClient(SetBackend(NewMemoryStore().NewBackend()))
+	client.Connect()
+
+	for _, maxLeases := range []int{0, -1} {
+		semaphore := NewSemaphore(client, path, maxLeases)
+		max, err := semaphore.MaxLeases()
+		assert.Nil(err)
+		assert.Equal(max, 1)
+
+		leases, err := semaphore.Acquire(context.Background(), 1)
+		assert.Nil(err)
+		assert.Nil(leases[0].Close())
+
+		assert.Nil(client.ops(nil).deleteBaseNode(path + "/" + semaphoreMaxPath))
+	}
+
+	client.Disconnect()
+}
@@ -0,0 +1,364 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ErrNoServiceInstance returned when a service has no instance to select
+var ErrNoServiceInstance = errors.New("No service instance")
+
+// ServiceInstance instance of a service, stored as JSON
+type ServiceInstance struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// ServiceRegistry registers service instances as ephemeral nodes, so
+// instances whose session is gone are no longer listed. Instances come
+// back by themselves after the session expires.
+type ServiceRegistry struct {
+	client *Client
+	ops    *clientOps
+	path   string
+
+	mu          sync.Mutex
+	registered  map[string]ServiceInstance
+	unsubscribe func()
+	closed      chan struct{}
+}
+
+// Register registers instance or updates its data when it's already
+// registered by this registry. An empty ID is replaced by a new GUID.
+func (r *ServiceRegistry) Register(instance ServiceInstance) (ServiceInstance, error) {
+	if !r.client.isConnected() {
+		return instance, errors.New("Client not connected")
+	}
+
+	if instance.Name == "" {
+		return instance, errors.New("Service name is required")
+	}
+
+	// both are node names
+	if strings.Contains(instance.Name, "/") || strings.Contains(instance.ID, "/") {
+		return instance, errors.New("Service instance [" + instance.Name + "/" + instance.ID + "] name and id can't contain /")
+	}
+
+	if instance.ID == "" {
+		guid, err := newNodeGUID()
+		if err != nil {
+			return instance, err
+		}
+		instance.ID = guid
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.register(instance); err != nil {
+		return instance, err
+	}
+	r.registered[r.instancePath(instance.Name, instance.ID)] = instance
+
+	if r.unsubscribe == nil {
+		states, unsubscribe := r.client.subscribeConnectionState()
+		r.unsubscribe = unsubscribe
+		r.closed = make(chan struct{})
+		go r.watchConnection(states, r.closed)
+	}
+	return instance, nil
+}
+
+// Unregister removes the instance registered by this registry
+func (r *ServiceRegistry) Unregister(name, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := r.instancePath(name, id)
+	if _, ok := r.registered[path]; !ok {
+		return errors.New("Service instance [" + name + "/" + id + "] not registered")
+	}
+
+	// kept while the node is there, so it's registered again after an
+	// expire and Unregister can be tried again
+	if err := r.ops.deleteNodeLastVersion(path); err != nil {
+		return fmt.Errorf("Could not remove node %s - %s", path, err.Error())
+	}
+	delete(r.registered, path)
+	return nil
+}
+
+// Close unregisters every instance registered by this registry
+func (r *ServiceRegistry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.unsubscribe != nil {
+		close(r.closed)
+		r.unsubscribe()
+		r.unsubscribe = nil
+	}
+
+	var lastErr error
+	for path := range r.registered {
+		if err := r.ops.deleteNodeLastVersion(path); err != nil {
+			lastErr = fmt.Errorf("Could not remove node %s - %s", path, err.Error())
+			continue
+		}
+		delete(r.registered, path)
+	}
+	return lastErr
+}
+
+// Services returns the names of every service registered
+func (r *ServiceRegistry) Services() ([]string, error) {
+	names, err := r.ops.getSortedNodeGUIDList(r.path)
+	if err == zk.ErrNoNode {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", err.Error(), r.path)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Instances returns the instances of service name ordered by ID
+func (r *ServiceRegistry) Instances(name string) ([]ServiceInstance, error) {
+	servicePath := r.path + "/" + name
+
+	ids, err := r.ops.getSortedNodeGUIDList(servicePath)
+	if err == zk.ErrNoNode {
+		return []ServiceInstance{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s - %s", err.Error(), servicePath)
+	}
+
+	instances := make([]ServiceInstance, 0, len(ids))
+	for _, id := range ids {
+		data, _, err := r.ops.get(servicePath + "/" + id)
+		if err == zk.ErrNoNode {
+			// unregistered meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", err.Error(), servicePath)
+		}
+
+		var instance ServiceInstance
+		if err := json.Unmarshal(data, &instance); err != nil {
+			r.client.logger.Errorf("Could not decode %s/%s - %s", servicePath, id, err.Error())
+			continue
+		}
+		instances = append(instances, instance)
+	}
+
+	sortServiceInstances(instances)
+	return instances, nil
+}
+
+// register creates the instance node, or updates it when it's already
+// owned by the current session
+func (r *ServiceRegistry) register(instance ServiceInstance) error {
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	servicePath := r.path + "/" + instance.Name
+	if _, err := r.ops.createParentNodeIfNotExists(servicePath, []byte{}); err != nil {
+		return err
+	}
+
+	path := r.instancePath(instance.Name, instance.ID)
+	if _, err := r.ops.backend.Create(path, data, zk.FlagEphemeral); err != nil {
+		if err != zk.ErrNodeExists {
+			return fmt.Errorf("%s - %s", err.Error(), path)
+		}
+		if !r.ops.ownsNode(path) {
+			return errors.New("Service instance [" + instance.Name + "/" + instance.ID + "] registered by another client")
+		}
+		if _, err := r.ops.setNodeData(path, data, -1); err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), path)
+		}
+	}
+	return nil
+}
+
+// watchConnection registers instances again when they went away with an
+// expired session
+func (r *ServiceRegistry) watchConnection(states <-chan ConnectionState, closed chan struct{}) {
+	for {
+		select {
+		case state := <-states:
+			if state != ConnectionStateConnected {
+				continue
+			}
+
+			r.mu.Lock()
+			for path, instance := range r.registered {
+				if r.ops.ownsNode(path) {
+					continue
+				}
+				if err := r.register(instance); err != nil {
+					r.client.logger.Errorf("Could not register %s - %s", path, err.Error())
+				}
+			}
+			r.mu.Unlock()
+		case <-closed:
+			return
+		case <-r.ops.done:
+			return
+		}
+	}
+}
+
+func (r *ServiceRegistry) instancePath(name, id string) string {
+	return r.path + "/" + name + "/" + id
+}
+
+func sortServiceInstances(instances []ServiceInstance) {
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+}
+
+// ServiceCache local copy of the instances of a service, kept up to date
+// with watches until it's closed
+type ServiceCache struct {
+	registry *ServiceRegistry
+	name     string
+
+	mu        sync.RWMutex
+	instances map[string]ServiceInstance
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+}
+
+// Cache returns a watched cache of the instances of service name, it's
+// loaded when returned
+func (r *ServiceRegistry) Cache(name string) (*ServiceCache, error) {
+	if !r.client.isConnected() {
+		return nil, errors.New("Client not connected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := ServiceCache{
+		registry:  r,
+		name:      name,
+		instances: make(map[string]ServiceInstance),
+		cancel:    cancel,
+		stopped:   make(chan struct{}),
+	}
+
+	loaded := make(chan error, 1)
+	go sc.run(ctx, loaded)
+
+	if err := <-loaded; err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// Instances returns the cached instances ordered by ID
+func (sc *ServiceCache) Instances() []ServiceInstance {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	instances := make([]ServiceInstance, 0, len(sc.instances))
+	for _, instance := range sc.instances {
+		instances = append(instances, instance)
+	}
+	sortServiceInstances(instances)
+	return instances
+}
+
+// Select returns the cached instance picked by selector
+func (sc *ServiceCache) Select(selector ServiceSelector) (ServiceInstance, error) {
+	instances := sc.Instances()
+	if len(instances) == 0 {
+		return ServiceInstance{}, ErrNoServiceInstance
+	}
+	return selector.Select(instances)
+}
+
+// Close stops watching the service
+func (sc *ServiceCache) Close() {
+	sc.cancel()
+	<-sc.stopped
+}
+
+// run keeps instances up to date until ctx is done, the error of the first
+// load is sent to loaded
+func (sc *ServiceCache) run(ctx context.Context, loaded chan<- error) {
+	defer close(sc.stopped)
+
+	r := sc.registry
+	servicePath := r.path + "/" + sc.name
+
+	r.ops.watchChildren(ctx, servicePath, func(change childChange) bool {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+
+		if change.removed {
+			delete(sc.instances, change.name)
+			return true
+		}
+
+		var instance ServiceInstance
+		if err := json.Unmarshal(change.data, &instance); err != nil {
+			r.client.logger.Errorf("Could not decode %s/%s - %s", servicePath, change.name, err.Error())
+			return true
+		}
+		sc.instances[change.name] = instance
+		return true
+	}, func(err error) {
+		if loaded == nil {
+			return
+		}
+		if err != nil {
+			err = fmt.Errorf("%s - %s", err.Error(), servicePath)
+		}
+		loaded <- err
+		loaded = nil
+	})
+}
+
+// ServiceRegistryOptionsFunc service registry definition
+type ServiceRegistryOptionsFunc func(*ServiceRegistry)
+
+// SetServiceRegistryRetryPolicy overrides client retry policy for this
+// registry
+func SetServiceRegistryRetryPolicy(policy RetryPolicy) ServiceRegistryOptionsFunc {
+	return func(r *ServiceRegistry) {
+		r.ops.policy = policy
+	}
+}
+
+// NewServiceRegistry returns new service registry keeping services at path
+func NewServiceRegistry(c *Client, path string, options ...ServiceRegistryOptionsFunc) *ServiceRegistry {
+	r := ServiceRegistry{
+		client:     c,
+		ops:        c.ops(nil),
+		path:       path,
+		registered: make(map[string]ServiceInstance),
+	}
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	return &r
+}
@@ -0,0 +1,207 @@
+package supervisor
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceRegistry(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/services/registry01"
+
+	registry := NewServiceRegistry(clients[0], path)
+	other := NewServiceRegistry(clients[1], path)
+
+	services, err := other.Services()
+	assert.Equal(err, nil)
+	assert.Equal(len(services), 0)
+
+	_, err = registry.Register(ServiceInstance{})
+	assert.NotEqual(err, nil)
+	_, err = registry.Register(ServiceInstance{Name: "api/v1", ID: "api01"})
+	assert.NotEqual(err, nil)
+	_, err = registry.Register(ServiceInstance{Name: "api", ID: "../api01"})
+	assert.NotEqual(err, nil)
+
+	api01, err := registry.Register(ServiceInstance{Name: "api", ID: "api01", Address: "10.0.0.1", Port: 80})
+	assert.Equal(err, nil)
+	api02, err := registry.Register(ServiceInstance{Name: "api", Address: "10.0.0.2", Port: 80, Payload: []byte("v2")})
+	assert.Equal(err, nil)
+	assert.NotEqual(api02.ID, "")
+	_, err = registry.Register(ServiceInstance{Name: "db", ID: "db01", Address: "10.0.0.3", Port: 5432})
+	assert.Equal(err, nil)
+
+	// instances belong to the client that registered them
+	_, err = other.Register(ServiceInstance{Name: "api", ID: "api01", Address: "10.0.0.4", Port: 80})
+	assert.NotEqual(err, nil)
+
+	services, err = other.Services()
+	assert.Equal(err, nil)
+	assert.Equal(services, []string{"api", "db"})
+
+	expected := []ServiceInstance{api01, api02}
+	sortServiceInstances(expected)
+	instances, err := other.Instances("api")
+	assert.Equal(err, nil)
+	assert.Equal(instances, expected)
+
+	// registering again updates the instance
+	api01.Port = 81
+	_, err = registry.Register(api01)
+	assert.Equal(err, nil)
+
+	assert.Equal(registry.Unregister("api", api02.ID), nil)
+	assert.NotEqual(registry.Unregister("api", api02.ID), nil)
+
+	instances, err = other.Instances("api")
+	assert.Equal(err, nil)
+	assert.Equal(instances, []ServiceInstance{api01})
+
+	assert.Equal(registry.Close(), nil)
+	instances, err = other.Instances("db")
+	assert.Equal(err, nil)
+	assert.Equal(len(instances), 0)
+
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
+
+func TestServiceCache(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/services/registry02"
+
+	registry := NewServiceRegistry(clients[0], path)
+	cache, err := NewServiceRegistry(clients[1], path).Cache("api")
+	assert.Equal(err, nil)
+	assert.Equal(len(cache.Instances()), 0)
+
+	_, err = cache.Select(NewRandomSelector())
+	assert.Equal(err, ErrNoServiceInstance)
+
+	api01, err := registry.Register(ServiceInstance{Name: "api", ID: "api01", Address: "10.0.0.1", Port: 80})
+	assert.Equal(err, nil)
+	api02, err := registry.Register(ServiceInstance{Name: "api", ID: "api02", Address: "10.0.0.2", Port: 80})
+	assert.Equal(err, nil)
+
+	assert.Eventually(func() bool {
+		return reflect.DeepEqual(cache.Instances(), []ServiceInstance{api01, api02})
+	}, time.Second, 10*time.Millisecond)
+
+	selector := NewRoundRobinSelector()
+	instance, err := cache.Select(selector)
+	assert.Equal(err, nil)
+	assert.Equal(instance, api01)
+	instance, err = cache.Select(selector)
+	assert.Equal(err, nil)
+	assert.Equal(instance, api02)
+
+	api01.Payload = []byte("draining")
+	_, err = registry.Register(api01)
+	assert.Equal(err, nil)
+	assert.Eventually(func() bool {
+		return reflect.DeepEqual(cache.Instances(), []ServiceInstance{api01, api02})
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(registry.Unregister("api", "api01"), nil)
+	assert.Eventually(func() bool {
+		return reflect.DeepEqual(cache.Instances(), []ServiceInstance{api02})
+	}, time.Second, 10*time.Millisecond)
+
+	cache.Close()
+	assert.Equal(registry.Close(), nil)
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
+
+func TestServiceRegistryExpired(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/services/registry03"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+	observerClient := newTestClient()
+
+	registry := NewServiceRegistry(client, path)
+	observer := NewServiceRegistry(observerClient, path)
+	instance, err := registry.Register(ServiceInstance{Name: "api", ID: "api01", Address: "10.0.0.1", Port: 80})
+	assert.Equal(err, nil)
+
+	cache, err := observer.Cache("api")
+	assert.Equal(err, nil)
+	assert.Equal(cache.Instances(), []ServiceInstance{instance})
+
+	backend.Expire()
+
+	// instance registered again with a new session
+	assert.Eventually(func() bool {
+		instances, _ := observer.Instances("api")
+		return len(instances) == 1 && registry.ops.ownsNode(registry.instancePath("api", "api01"))
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(func() bool {
+		return reflect.DeepEqual(cache.Instances(), []ServiceInstance{instance})
+	}, time.Second, 10*time.Millisecond)
+
+	cache.Close()
+	assert.Equal(registry.Close(), nil)
+	assert.Equal(removeTestTree(client, path), nil)
+	client.Disconnect()
+	observerClient.Disconnect()
+}
+
+// unauthorizedBackend refuses to list children
+type unauthorizedBackend struct {
+	*MemoryBackend
+}
+
+func (b *unauthorizedBackend) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	return nil, nil, nil, zk.ErrNoAuth
+}
+
+func TestServiceCacheLoadError(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/services/registry04"
+
+	client := NewClient(SetBackend(&unauthorizedBackend{testStore.NewBackend()}))
+	assert.Equal(client.Connect(), nil)
+
+	cache, err := NewServiceRegistry(client, path).Cache("api")
+	assert.Nil(cache)
+	assert.Equal(err, fmt.Errorf("%s - %s", zk.ErrNoAuth.Error(), path+"/api"))
+
+	client.Disconnect()
+}
+
+func TestServiceRegistryUnregisterAgain(t *testing.T) {
+	assert := assert.New(t)
+	path := "/supervisor/test/services/registry05"
+
+	backend := testStore.NewBackend()
+	client := NewClient(SetBackend(backend))
+	assert.Equal(client.Connect(), nil)
+
+	registry := NewServiceRegistry(client, path, SetServiceRegistryRetryPolicy(NewFixedRetry(0, 0)))
+	_, err := registry.Register(ServiceInstance{Name: "api", ID: "api01"})
+	assert.Equal(err, nil)
+
+	// still registered when the node can't be removed
+	backend.Suspend()
+	assert.NotEqual(registry.Unregister("api", "api01"), nil)
+	backend.Resume()
+
+	assert.Equal(registry.Unregister("api", "api01"), nil)
+	instances, err := registry.Instances("api")
+	assert.Equal(err, nil)
+	assert.Equal(len(instances), 0)
+
+	assert.Equal(registry.Close(), nil)
+	assert.Equal(removeTestTree(client, path), nil)
+	client.Disconnect()
+}
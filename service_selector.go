@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// ServiceSelector picks one instance among the instances of a service,
+// instances are ordered by ID and never empty
+type ServiceSelector interface {
+	Select(instances []ServiceInstance) (ServiceInstance, error)
+}
+
+// RoundRobinSelector picks instances in turn
+type RoundRobinSelector struct {
+	mu   sync.Mutex
+	next int
+}
+
+// Select returns the instance after the one picked last time
+func (s *RoundRobinSelector) Select(instances []ServiceInstance) (ServiceInstance, error) {
+	if len(instances) == 0 {
+		return ServiceInstance{}, ErrNoServiceInstance
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance := instances[s.next%len(instances)]
+	s.next = (s.next + 1) % len(instances)
+	return instance, nil
+}
+
+// NewRoundRobinSelector returns new round-robin selector
+func NewRoundRobinSelector() *RoundRobinSelector {
+	return &RoundRobinSelector{}
+}
+
+// RandomSelector picks a random instance
+type RandomSelector struct{}
+
+// Select returns a random instance
+func (s *RandomSelector) Select(instances []ServiceInstance) (ServiceInstance, error) {
+	if len(instances) == 0 {
+		return ServiceInstance{}, ErrNoServiceInstance
+	}
+	return instances[rand.Intn(len(instances))], nil
+}
+
+// NewRandomSelector returns new random selector
+func NewRandomSelector() *RandomSelector {
+	return &RandomSelector{}
+}
+
+// StickySelector keeps picking the same instance while it's registered,
+// another one is picked with selector when it's gone
+type StickySelector struct {
+	selector ServiceSelector
+
+	mu sync.Mutex
+	id string
+}
+
+// Select returns the instance picked before when it's still there
+func (s *StickySelector) Select(instances []ServiceInstance) (ServiceInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, instance := range instances {
+		if instance.ID == s.id {
+			return instance, nil
+		}
+	}
+
+	instance, err := s.selector.Select(instances)
+	if err != nil {
+		return instance, err
+	}
+	s.id = instance.ID
+	return instance, nil
+}
+
+// NewStickySelector returns new sticky selector picking instances with
+// selector, random when nil
+func NewStickySelector(selector ServiceSelector) *StickySelector {
+	if selector == nil {
+		selector = NewRandomSelector()
+	}
+	return &StickySelector{selector: selector}
+}
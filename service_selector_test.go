@@ -0,0 +1,50 @@
+package supervisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceSelectors(t *testing.T) {
+	assert := assert.New(t)
+	instances := []ServiceInstance{
+		{Name: "api", ID: "api01"},
+		{Name: "api", ID: "api02"},
+		{Name: "api", ID: "api03"},
+	}
+
+	roundRobin := NewRoundRobinSelector()
+	for i := 0; i < 6; i++ {
+		instance, err := roundRobin.Select(instances)
+		assert.Equal(err, nil)
+		assert.Equal(instance, instances[i%3])
+	}
+
+	random := NewRandomSelector()
+	for i := 0; i < 10; i++ {
+		instance, err := random.Select(instances)
+		assert.Equal(err, nil)
+		assert.Contains(instances, instance)
+	}
+
+	sticky := NewStickySelector(NewRoundRobinSelector())
+	for i := 0; i < 3; i++ {
+		instance, err := sticky.Select(instances)
+		assert.Equal(err, nil)
+		assert.Equal(instance, instances[0])
+	}
+
+	// another instance is picked once the sticky one is gone
+	instance, err := sticky.Select(instances[1:])
+	assert.Equal(err, nil)
+	assert.Equal(instance, instances[2])
+	instance, err = sticky.Select(instances)
+	assert.Equal(err, nil)
+	assert.Equal(instance, instances[2])
+
+	for _, selector := range []ServiceSelector{roundRobin, random, sticky} {
+		_, err := selector.Select(nil)
+		assert.Equal(err, ErrNoServiceInstance)
+	}
+}
@@ -0,0 +1,362 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	shardedCounterShardsPath = "shards"
+	shardedCounterValuesPath = "values"
+)
+
+// ShardedCounter counter spread across several shard nodes, so clients
+// incrementing it at the same time rarely conflict. Every increment goes
+// to a random shard and Get sums every shard. The number of shards is
+// kept in a shared node, so every participant agrees on it.
+type ShardedCounter struct {
+	client  *Client
+	ops     *clientOps
+	path    string
+	initial uint64
+	options []AtomicOptionsFunc
+
+	// batch interval, increments are only kept locally when set
+	batch time.Duration
+
+	mu      sync.Mutex
+	started bool
+	shards  *AtomicUint64
+	count   int
+	values  map[int]*AtomicUint64
+	pending uint64
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// Increment increments the counter
+func (sc *ShardedCounter) Increment() error {
+	return sc.AddContext(context.Background(), 1)
+}
+
+// Add adds delta to the counter
+func (sc *ShardedCounter) Add(delta uint64) error {
+	return sc.AddContext(context.Background(), delta)
+}
+
+// AddContext adds delta to the counter, retries stop when ctx is done. With
+// batching delta is only added locally and saved on the next flush.
+func (sc *ShardedCounter) AddContext(ctx context.Context, delta uint64) error {
+	if err := sc.init(); err != nil {
+		return err
+	}
+
+	if sc.batch > 0 {
+		sc.mu.Lock()
+		sc.pending += delta
+		sc.mu.Unlock()
+		return nil
+	}
+
+	_, err := sc.shard().AddContext(ctx, delta)
+	return err
+}
+
+// Flush saves increments batched locally
+func (sc *ShardedCounter) Flush() error {
+	return sc.FlushContext(context.Background())
+}
+
+// FlushContext saves increments batched locally, retries stop when ctx is
+// done. When saving fails the increments are kept for the next flush.
+func (sc *ShardedCounter) FlushContext(ctx context.Context) error {
+	sc.mu.Lock()
+	pending := sc.pending
+	sc.pending = 0
+	sc.mu.Unlock()
+
+	if pending == 0 {
+		return nil
+	}
+
+	if _, err := sc.shard().AddContext(ctx, pending); err != nil {
+		sc.mu.Lock()
+		sc.pending += pending
+		sc.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Get returns the sum of every shard, increments not flushed yet are not
+// counted
+func (sc *ShardedCounter) Get() (uint64, error) {
+	valuesPath := sc.path + "/" + shardedCounterValuesPath
+
+	children, err := sc.ops.getSortedNodeGUIDList(valuesPath)
+	if err == zk.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s - %s", err.Error(), valuesPath)
+	}
+
+	var sum uint64
+	for _, child := range children {
+		data, _, err := sc.ops.checkAndGetNode(valuesPath + "/" + child)
+		if err != nil {
+			return 0, err
+		}
+		sum += sc.shards.fromBytes(data)
+	}
+	return sum, nil
+}
+
+// Shards returns the shared number of shards
+func (sc *ShardedCounter) Shards() (int, error) {
+	if err := sc.init(); err != nil {
+		return 0, err
+	}
+
+	shards, err := sc.shards.Get()
+	if err != nil {
+		return 0, err
+	}
+	return int(shards), nil
+}
+
+// Reshard changes the shared number of shards. Values of shards above the
+// new number are moved to the remaining ones, each move is a transaction so
+// no increment is lost or counted twice. Participants that didn't see the
+// change yet may create them again, Get still counts them.
+func (sc *ShardedCounter) Reshard(shards int) error {
+	if shards <= 0 {
+		return fmt.Errorf("Invalid number of shards %d", shards)
+	}
+
+	if err := sc.init(); err != nil {
+		return err
+	}
+
+	if _, err := sc.shards.TrySet(uint64(shards)); err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.count = shards
+	sc.mu.Unlock()
+
+	return sc.fold(shards)
+}
+
+// fold moves values of shards at or above shards to the remaining ones
+func (sc *ShardedCounter) fold(shards int) error {
+	valuesPath := sc.path + "/" + shardedCounterValuesPath
+
+	children, err := sc.ops.getSortedNodeGUIDList(valuesPath)
+	if err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), valuesPath)
+	}
+
+	for _, child := range children {
+		idx, err := strconv.Atoi(child)
+		if err != nil || idx < shards {
+			continue
+		}
+		if err := sc.move(sc.shardPath(idx), sc.shardPath(idx%shards)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move removes shard from and adds its value to shard to
+func (sc *ShardedCounter) move(from, to string) error {
+	for {
+		data, stat, err := sc.ops.get(from)
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), from)
+		}
+
+		tdata, tstat, err := sc.ops.checkAndGetNode(to)
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), to)
+		}
+
+		sum := sc.shards.toBytes(sc.shards.fromBytes(tdata) + sc.shards.fromBytes(data))
+		txn := sc.ops.txn().Delete(from, stat.Version)
+		if tstat == nil {
+			txn.Create(to, sum, 0)
+		} else {
+			txn.Set(to, sum, tstat.Version)
+		}
+
+		_, err = txn.Commit()
+		if err == zk.ErrBadVersion || err == zk.ErrNodeExists || err == zk.ErrNoNode {
+			// shards changed meanwhile
+			continue
+		}
+		return err
+	}
+}
+
+func (sc *ShardedCounter) shardPath(idx int) string {
+	return fmt.Sprintf("%s/%s/%010d", sc.path, shardedCounterValuesPath, idx)
+}
+
+// Close stops following the number of shards and flushes increments
+// batched locally
+func (sc *ShardedCounter) Close() error {
+	sc.mu.Lock()
+	if !sc.started {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.started = false
+	sc.cancel()
+	sc.mu.Unlock()
+
+	<-sc.stopped
+	return sc.Flush()
+}
+
+// init creates the shards node with the initial number of shards unless
+// another participant already did it, and starts following it
+func (sc *ShardedCounter) init() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.started {
+		return nil
+	}
+
+	if !sc.client.isConnected() {
+		return errors.New("Client not connected")
+	}
+
+	shardsPath := sc.path + "/" + shardedCounterShardsPath
+	if _, err := sc.ops.createParentNodeIfNotExists(shardsPath, sc.shards.toBytes(sc.initial)); err != nil {
+		return err
+	}
+	if _, err := sc.ops.createParentNodeIfNotExists(sc.path+"/"+shardedCounterValuesPath, []byte{}); err != nil {
+		return err
+	}
+
+	shards, err := sc.shards.Get()
+	if err != nil {
+		return err
+	}
+	sc.count = int(shards)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc.cancel = cancel
+	sc.stopped = make(chan struct{})
+	sc.started = true
+
+	go sc.run(ctx, sc.shards.Watch(ctx))
+	return nil
+}
+
+// run follows changes of the number of shards and flushes batched
+// increments until ctx is done
+func (sc *ShardedCounter) run(ctx context.Context, changes <-chan ValueChange[uint64]) {
+	defer close(sc.stopped)
+
+	var tick <-chan time.Time
+	if sc.batch > 0 {
+		ticker := time.NewTicker(sc.batch)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if change.NewValue > 0 {
+				sc.mu.Lock()
+				sc.count = int(change.NewValue)
+				sc.mu.Unlock()
+			}
+		case <-tick:
+			if err := sc.FlushContext(ctx); err != nil {
+				sc.client.logger.Errorf("Could not flush %s - %s", sc.path, err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// shard returns a random shard among the current number of shards
+func (sc *ShardedCounter) shard() *AtomicUint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	idx := 0
+	if sc.count > 1 {
+		idx = rand.Intn(sc.count)
+	}
+
+	value, ok := sc.values[idx]
+	if !ok {
+		value = NewAtomicUint64(sc.client, sc.shardPath(idx), sc.options...)
+		sc.values[idx] = value
+	}
+	return value
+}
+
+// ShardedCounterOptionsFunc sharded counter definition
+type ShardedCounterOptionsFunc func(*ShardedCounter)
+
+// SetShardedCounterBatch keeps increments locally and saves them every
+// interval, Flush and Close save them right away
+func SetShardedCounterBatch(interval time.Duration) ShardedCounterOptionsFunc {
+	return func(sc *ShardedCounter) {
+		sc.batch = interval
+	}
+}
+
+// SetShardedCounterRetryPolicy overrides client retry policy for this
+// counter, it's used for connection errors and for conflicting changes
+func SetShardedCounterRetryPolicy(policy RetryPolicy) ShardedCounterOptionsFunc {
+	return func(sc *ShardedCounter) {
+		sc.ops.policy = policy
+	}
+}
+
+// NewShardedCounter returns new counter spread across shards nodes, when
+// the shared number of shards already exists its value is used instead
+func NewShardedCounter(c *Client, path string, shards int, options ...ShardedCounterOptionsFunc) *ShardedCounter {
+	if shards <= 0 {
+		shards = 1
+	}
+
+	sc := ShardedCounter{
+		client:  c,
+		ops:     c.ops(nil),
+		path:    path,
+		initial: uint64(shards),
+		values:  make(map[int]*AtomicUint64),
+	}
+
+	for _, option := range options {
+		option(&sc)
+	}
+
+	sc.options = []AtomicOptionsFunc{SetAtomicRetryPolicy(sc.ops.policy)}
+	sc.shards = NewAtomicUint64(c, path+"/"+shardedCounterShardsPath, sc.options...)
+	return &sc
+}
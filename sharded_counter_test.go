@@ -0,0 +1,100 @@
+package supervisor
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCounter(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(4)
+	path := "/supervisor/test/sharded/counter01"
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		counter := NewShardedCounter(client, path, 4)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := 0; idx < 25; idx++ {
+				assert.Equal(counter.Increment(), nil)
+			}
+			assert.Equal(counter.Close(), nil)
+		}()
+	}
+	wg.Wait()
+
+	counter := NewShardedCounter(clients[0], path, 1)
+	shards, err := counter.Shards()
+	assert.Equal(err, nil)
+	assert.Equal(shards, 4)
+
+	sum, err := counter.Get()
+	assert.Equal(err, nil)
+	assert.Equal(sum, uint64(100))
+
+	// values of removed shards are moved to the remaining ones
+	assert.Equal(counter.Reshard(2), nil)
+	assert.Equal(counter.Add(10), nil)
+	sum, _ = counter.Get()
+	assert.Equal(sum, uint64(110))
+
+	children, err := clients[0].ops(nil).getSortedNodeGUIDList(path + "/" + shardedCounterValuesPath)
+	assert.Equal(err, nil)
+	assert.True(len(children) <= 2)
+
+	assert.Equal(counter.Close(), nil)
+	assert.Equal(removeTestTree(clients[0], path), nil)
+	closeClients(clients)
+}
+
+func TestShardedCounterBatch(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+	path := "/supervisor/test/sharded/counter02"
+
+	counter := NewShardedCounter(client, path, 2, SetShardedCounterBatch(time.Hour))
+	for idx := 0; idx < 10; idx++ {
+		assert.Equal(counter.Increment(), nil)
+	}
+
+	sum, _ := counter.Get()
+	assert.Equal(sum, uint64(0))
+
+	assert.Equal(counter.Flush(), nil)
+	sum, _ = counter.Get()
+	assert.Equal(sum, uint64(10))
+
+	assert.Equal(counter.Add(5), nil)
+	assert.Equal(counter.Close(), nil)
+	sum, _ = counter.Get()
+	assert.Equal(sum, uint64(15))
+
+	assert.Equal(removeTestTree(client, path), nil)
+	client.Disconnect()
+}
+
+func TestShardedCounterReshardFollowed(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/sharded/counter03"
+
+	counter01 := NewShardedCounter(clients[0], path, 1)
+	counter02 := NewShardedCounter(clients[1], path, 1)
+	assert.Equal(counter01.Increment(), nil)
+	assert.Equal(counter02.Increment(), nil)
+
+	assert.Equal(counter01.Reshard(8), nil)
+	assert.Eventually(func() bool {
+		counter02.mu.Lock()
+		defer counter02.mu.Unlock()
+		return counter02.count == 8
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(counter01.Close(), nil)
+	assert.Equal(counter02.Close(), nil)
+	closeClients(clients)
+}
@@ -0,0 +1,99 @@
+package supervisor
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Txn operations committed atomically, either every operation is applied
+// or none of them
+type Txn struct {
+	ops      *clientOps
+	requests []interface{}
+}
+
+// TxnResult result of one operation of a transaction
+type TxnResult struct {
+	// Path node path, for sequential nodes the path created
+	Path string
+	// Stat node stat after Set
+	Stat *zk.Stat
+	// Err set when the transaction failed, operations before the failing
+	// one have no error and the ones after it zk.ErrUnknown
+	Err error
+}
+
+// Txn returns new empty transaction
+func (c *Client) Txn() *Txn {
+	return c.ops(nil).txn()
+}
+
+func (o *clientOps) txn() *Txn {
+	return &Txn{ops: o}
+}
+
+// Create creates node, flags accepts zk.FlagEphemeral and zk.FlagSequence
+func (t *Txn) Create(path string, data []byte, flags int32) *Txn {
+	t.requests = append(t.requests, &zk.CreateRequest{Path: path, Data: data, Flags: flags})
+	return t
+}
+
+// Set sets node data if version matches, -1 matches any version
+func (t *Txn) Set(path string, data []byte, version int32) *Txn {
+	t.requests = append(t.requests, &zk.SetDataRequest{Path: path, Data: data, Version: version})
+	return t
+}
+
+// Delete deletes node if version matches, -1 matches any version
+func (t *Txn) Delete(path string, version int32) *Txn {
+	t.requests = append(t.requests, &zk.DeleteRequest{Path: path, Version: version})
+	return t
+}
+
+// Check fails the transaction unless node version matches, -1 matches any
+// version
+func (t *Txn) Check(path string, version int32) *Txn {
+	t.requests = append(t.requests, &zk.CheckVersionRequest{Path: path, Version: version})
+	return t
+}
+
+// Len returns the number of operations
+func (t *Txn) Len() int {
+	return len(t.requests)
+}
+
+// Commit applies every operation, the error is the one of the failing
+// operation. It isn't retried, a transaction that went through before the
+// connection failed could fail on a new attempt.
+func (t *Txn) Commit() ([]TxnResult, error) {
+	if len(t.requests) == 0 {
+		return nil, nil
+	}
+
+	responses, err := t.ops.backend.Multi(t.requests...)
+	if len(responses) != len(t.requests) {
+		return nil, err
+	}
+
+	results := make([]TxnResult, len(t.requests))
+	for idx, request := range t.requests {
+		results[idx] = TxnResult{Path: t.path(request), Stat: responses[idx].Stat, Err: responses[idx].Error}
+		if responses[idx].String != "" {
+			results[idx].Path = responses[idx].String
+		}
+	}
+	return results, err
+}
+
+func (t *Txn) path(request interface{}) string {
+	switch request := request.(type) {
+	case *zk.CreateRequest:
+		return request.Path
+	case *zk.SetDataRequest:
+		return request.Path
+	case *zk.DeleteRequest:
+		return request.Path
+	case *zk.CheckVersionRequest:
+		return request.Path
+	}
+	return ""
+}
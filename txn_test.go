@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnCommit(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+	path := "/supervisor/test/txn/commit"
+
+	_, err := client.ops(nil).createParentNodeIfNotExists(path, []byte{})
+	assert.Equal(err, nil)
+
+	results, err := client.Txn().
+		Create(path+"/a", []byte("a"), 0).
+		Create(path+"/seq-", []byte{}, zk.FlagSequence).
+		Set(path+"/a", []byte("b"), 0).
+		Check(path, -1).
+		Commit()
+	assert.Equal(err, nil)
+	assert.Equal(len(results), 4)
+	assert.Equal(results[0].Path, path+"/a")
+	assert.Equal(results[1].Path, path+"/seq-0000000001")
+	assert.Equal(results[2].Stat.Version, int32(1))
+
+	data, _, err := client.ops(nil).get(path + "/a")
+	assert.Equal(err, nil)
+	assert.Equal(data, []byte("b"))
+
+	_, err = client.Txn().
+		Delete(path+"/a", 1).
+		Delete(path+"/seq-0000000001", -1).
+		Delete(path, -1).
+		Commit()
+	assert.Equal(err, nil)
+
+	exists, _, err := client.backend.Exists(path)
+	assert.Equal(err, nil)
+	assert.False(exists)
+
+	client.Disconnect()
+}
+
+func TestTxnRollback(t *testing.T) {
+	assert := assert.New(t)
+	clients := makeClientSlice(2)
+	path := "/supervisor/test/txn/rollback"
+
+	_, err := clients[0].ops(nil).createParentNodeIfNotExists(path, []byte("v0"))
+	assert.Equal(err, nil)
+
+	_, _, watch, err := clients[1].backend.GetW(path)
+	assert.Equal(err, nil)
+
+	results, err := clients[0].Txn().
+		Set(path, []byte("v1"), 0).
+		Create(path+"/child", []byte{}, 0).
+		Check(path, 0).
+		Create(path+"/other", []byte{}, 0).
+		Commit()
+	assert.Equal(err, zk.ErrBadVersion)
+	assert.Equal(len(results), 4)
+	assert.Equal(results[0].Err, nil)
+	assert.Equal(results[1].Err, nil)
+	assert.Equal(results[2].Err, zk.ErrBadVersion)
+	assert.Equal(results[3].Err, zk.ErrUnknown)
+
+	data, stat, err := clients[0].ops(nil).get(path)
+	assert.Equal(err, nil)
+	assert.Equal(data, []byte("v0"))
+	assert.Equal(stat.Version, int32(0))
+	assert.Equal(stat.NumChildren, int32(0))
+
+	// watches only fire for committed changes
+	select {
+	case <-watch:
+		t.Error("watch fired for a transaction rolled back")
+	default:
+	}
+
+	assert.Equal(clients[0].ops(nil).deleteBaseNode(path), nil)
+	exists, _, _ := clients[0].backend.Exists("/supervisor/test/txn")
+	assert.False(exists)
+
+	closeClients(clients)
+}
+
+func TestCreateWithParents(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient()
+	path := "/supervisor/test/txn/parents/lock"
+
+	npath, guid, err := client.ops(nil).createProtectedEphemeralSequential(path, []byte{})
+	assert.Equal(err, nil)
+	assert.Equal(npath, path+"/"+guid)
+
+	children, err := client.ops(nil).getSortedNodeGUIDList(path)
+	assert.Equal(err, nil)
+	assert.Equal(children, []string{guid})
+
+	assert.Equal(client.ops(nil).deleteNodeLastVersion(npath), nil)
+	assert.Equal(client.ops(nil).deleteBaseNode(path), nil)
+	client.Disconnect()
+}